@@ -0,0 +1,407 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const envPrefix = "TEMP0RAL_"
+
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Store    StoreConfig    `yaml:"store"`
+	Database DatabaseConfig `yaml:"database"`
+	Messages MessagesConfig `yaml:"messages"`
+	Rooms    RoomsConfig    `yaml:"rooms"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Session  SessionConfig  `yaml:"session"`
+	Cleanup  CleanupConfig  `yaml:"cleanup"`
+	Uploads  UploadsConfig  `yaml:"uploads"`
+	Export   ExportConfig   `yaml:"export"`
+	Shutdown ShutdownConfig `yaml:"shutdown"`
+}
+
+type ServerConfig struct {
+	Addr string `yaml:"addr"`
+}
+
+type StoreConfig struct {
+	Driver string `yaml:"driver"` // "postgres" or "memory"
+}
+
+type DatabaseConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslmode"`
+}
+
+type MessagesConfig struct {
+	HistoryLimit int           `yaml:"history_limit"` // Messages kept before the oldest are trimmed
+	PageSize     int           `yaml:"page_size"`     // Messages loaded at once when opening or scrolling back
+	EditWindow   time.Duration `yaml:"edit_window"`   // How long authors may edit a message; 0 disables editing
+}
+
+type RoomsConfig struct {
+	Default string `yaml:"default"` // Room /chat and new sessions land in
+}
+
+// roomIDPattern is what a room ID may look like, so it fits in a URL as is.
+var roomIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// ValidRoomID reports whether id can name a room: 1 to 32 lowercase
+// letters, digits and dashes, not starting with a dash.
+func ValidRoomID(id string) bool {
+	return roomIDPattern.MatchString(id)
+}
+
+type AuthConfig struct {
+	AccessKey    string `yaml:"access_key"`
+	ModeratorKey string `yaml:"moderator_key"` // Optional key granting moderator sessions
+}
+
+type SessionConfig struct {
+	Duration      time.Duration `yaml:"duration"`
+	IdleThreshold time.Duration `yaml:"idle_threshold"` // Users idle after this long without activity
+	MaxIdleTime   time.Duration `yaml:"max_idle_time"`  // Terminate sessions after this long without activity
+	PingInterval  time.Duration `yaml:"ping_interval"`  // WebSocket ping and heartbeat period
+}
+
+type CleanupConfig struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+type UploadsConfig struct {
+	MaxSize int64 `yaml:"max_size"`
+}
+
+type ExportConfig struct {
+	Enabled bool `yaml:"enabled"` // Whether users may download transcripts from /export
+}
+
+type ShutdownConfig struct {
+	Timeout time.Duration `yaml:"timeout"` // Upper bound on draining before the process exits
+	Purge   bool          `yaml:"purge"`   // Delete all messages and uploads on the way out
+}
+
+// Default returns the settings used when neither config.yml, the environment
+// nor the command line say otherwise.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr: ":8080",
+		},
+		Store: StoreConfig{
+			Driver: "postgres",
+		},
+		Database: DatabaseConfig{
+			Port:    5432,
+			SSLMode: "disable",
+		},
+		Messages: MessagesConfig{
+			HistoryLimit: 500,
+			PageSize:     50,
+			EditWindow:   5 * time.Minute,
+		},
+		Rooms: RoomsConfig{
+			Default: "lobby",
+		},
+		Session: SessionConfig{
+			Duration:      5 * time.Hour,
+			IdleThreshold: 3 * time.Second,
+			MaxIdleTime:   60 * time.Second,
+			PingInterval:  20 * time.Second,
+		},
+		Cleanup: CleanupConfig{
+			Interval: 30 * time.Second,
+		},
+		Uploads: UploadsConfig{
+			MaxSize: 5 * 1024 * 1024, // 5 MB upload limit!
+		},
+		Export: ExportConfig{
+			Enabled: true,
+		},
+		Shutdown: ShutdownConfig{
+			Timeout: 10 * time.Second,
+			Purge:   true,
+		},
+	}
+}
+
+// setting ties one configuration field to its command line flag and the
+// TEMP0RAL_* environment variable derived from the flag name.
+type setting struct {
+	flag  string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+func (s setting) env() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(s.flag, "-", "_"))
+}
+
+var settings = []setting{
+	{"addr", "HTTP listen address", func(c *Config, v string) error { c.Server.Addr = v; return nil }},
+	{"store", "message store driver: postgres or memory", func(c *Config, v string) error { c.Store.Driver = v; return nil }},
+	{"db-host", "Postgres host", func(c *Config, v string) error { c.Database.Host = v; return nil }},
+	{"db-port", "Postgres port", func(c *Config, v string) error { return setInt(&c.Database.Port, v) }},
+	{"db-user", "Postgres user", func(c *Config, v string) error { c.Database.User = v; return nil }},
+	{"db-password", "Postgres password", func(c *Config, v string) error { c.Database.Password = v; return nil }},
+	{"db-name", "Postgres database name", func(c *Config, v string) error { c.Database.Name = v; return nil }},
+	{"db-sslmode", "Postgres sslmode", func(c *Config, v string) error { c.Database.SSLMode = v; return nil }},
+	{"history-limit", "number of messages kept before the oldest are trimmed", func(c *Config, v string) error { return setInt(&c.Messages.HistoryLimit, v) }},
+	{"page-size", "number of messages loaded at once when opening a room or scrolling back", func(c *Config, v string) error { return setInt(&c.Messages.PageSize, v) }},
+	{"edit-window", "how long after posting a message can be edited (0 disables editing)", func(c *Config, v string) error { return setDuration(&c.Messages.EditWindow, v) }},
+	{"default-room", "room that /chat and new sessions land in", func(c *Config, v string) error { c.Rooms.Default = v; return nil }},
+	{"access-key", "access key required to enter the chat", func(c *Config, v string) error { c.Auth.AccessKey = v; return nil }},
+	{"moderator-key", "key that logs in as a moderator (empty disables moderators)", func(c *Config, v string) error { c.Auth.ModeratorKey = v; return nil }},
+	{"session-duration", "lifetime of a session", func(c *Config, v string) error { return setDuration(&c.Session.Duration, v) }},
+	{"idle-threshold", "inactivity before a user is shown as idle", func(c *Config, v string) error { return setDuration(&c.Session.IdleThreshold, v) }},
+	{"max-idle-time", "inactivity before a session is terminated", func(c *Config, v string) error { return setDuration(&c.Session.MaxIdleTime, v) }},
+	{"ping-interval", "how often WebSocket connections are pinged", func(c *Config, v string) error { return setDuration(&c.Session.PingInterval, v) }},
+	{"cleanup-interval", "how often expired sessions and messages are purged", func(c *Config, v string) error { return setDuration(&c.Cleanup.Interval, v) }},
+	{"max-upload-size", "maximum image upload size in bytes", func(c *Config, v string) error { return setInt64(&c.Uploads.MaxSize, v) }},
+	{"export", "let users download transcripts from /export", func(c *Config, v string) error { return setBool(&c.Export.Enabled, v) }},
+	{"shutdown-timeout", "how long a graceful shutdown may take", func(c *Config, v string) error { return setDuration(&c.Shutdown.Timeout, v) }},
+	{"shutdown-purge", "delete all messages and uploads on shutdown", func(c *Config, v string) error { return setBool(&c.Shutdown.Purge, v) }},
+}
+
+// Load builds the configuration from, in increasing order of precedence, the
+// defaults, the YAML file named by -config (config.yml unless overridden),
+// TEMP0RAL_* environment variables and command line flags. The result is
+// validated before it is returned.
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("temp0ral-chat", flag.ContinueOnError)
+	path := fs.String("config", "config.yml", "path to the YAML config file")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.flag] = fs.String(s.flag, "", s.usage+" (env "+s.env()+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	errs, err := cfg.loadFile(*path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env()); ok {
+			if err := s.set(cfg, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env(), err))
+			}
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if v, ok := values[f.Name]; ok {
+			s := lookupSetting(f.Name)
+			if err := s.set(cfg, *v); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", f.Name, err))
+			}
+		}
+	})
+
+	var invalid *Error
+	if errors.As(cfg.Validate(), &invalid) {
+		errs = append(errs, invalid.Problems...)
+	}
+	if len(errs) > 0 {
+		return nil, &Error{Problems: errs}
+	}
+	return cfg, nil
+}
+
+// loadFile reads the YAML file at path over c, if there is one. Unknown
+// keys and values of the wrong type are returned as problems, one per
+// field, so they are listed along with everything else that is invalid; an
+// unreadable or malformed file is an error.
+func (c *Config) loadFile(path string) ([]error, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err = dec.Decode(c)
+	var typeErr *yaml.TypeError
+	switch {
+	case err == nil, errors.Is(err, io.EOF):
+		return nil, nil
+	case errors.As(err, &typeErr):
+		problems := make([]error, len(typeErr.Errors))
+		for i, msg := range typeErr.Errors {
+			problems[i] = fmt.Errorf("%s: %s", path, msg)
+		}
+		return problems, nil
+	default:
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+}
+
+// Validate reports every out-of-range or missing field at once so a broken
+// deployment can be fixed in a single pass.
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Server.Addr == "" {
+		add("server.addr must not be empty")
+	}
+	switch c.Store.Driver {
+	case "postgres":
+		if c.Database.Host == "" {
+			add("database.host must not be empty")
+		}
+		if c.Database.Port < 1 || c.Database.Port > 65535 {
+			add("database.port must be between 1 and 65535, got %d", c.Database.Port)
+		}
+		if c.Database.User == "" {
+			add("database.user must not be empty")
+		}
+		if c.Database.Name == "" {
+			add("database.name must not be empty")
+		}
+	case "memory":
+	default:
+		add("store.driver must be \"postgres\" or \"memory\", got %q", c.Store.Driver)
+	}
+	if c.Messages.HistoryLimit <= 0 {
+		add("messages.history_limit must be positive, got %d", c.Messages.HistoryLimit)
+	}
+	if c.Messages.PageSize <= 0 {
+		add("messages.page_size must be positive, got %d", c.Messages.PageSize)
+	}
+	if c.Messages.EditWindow < 0 {
+		add("messages.edit_window must not be negative, got %v", c.Messages.EditWindow)
+	}
+	if !ValidRoomID(c.Rooms.Default) {
+		add("rooms.default must be 1-32 lowercase letters, digits or dashes, got %q", c.Rooms.Default)
+	}
+	if c.Auth.AccessKey == "" {
+		add("auth.access_key must not be empty")
+	}
+	if c.Auth.ModeratorKey != "" && c.Auth.ModeratorKey == c.Auth.AccessKey {
+		add("auth.moderator_key must differ from auth.access_key")
+	}
+	if c.Session.Duration <= 0 {
+		add("session.duration must be positive, got %v", c.Session.Duration)
+	}
+	if c.Session.IdleThreshold <= 0 {
+		add("session.idle_threshold must be positive, got %v", c.Session.IdleThreshold)
+	}
+	if c.Session.MaxIdleTime <= 0 {
+		add("session.max_idle_time must be positive, got %v", c.Session.MaxIdleTime)
+	}
+	if c.Session.IdleThreshold >= c.Session.MaxIdleTime {
+		add("session.idle_threshold (%v) must be less than session.max_idle_time (%v)",
+			c.Session.IdleThreshold, c.Session.MaxIdleTime)
+	}
+	if c.Session.MaxIdleTime > c.Session.Duration {
+		add("session.max_idle_time (%v) must not exceed session.duration (%v)",
+			c.Session.MaxIdleTime, c.Session.Duration)
+	}
+	if c.Session.PingInterval <= 0 {
+		add("session.ping_interval must be positive, got %v", c.Session.PingInterval)
+	} else if c.Session.PingInterval >= c.Session.MaxIdleTime {
+		add("session.ping_interval (%v) must be less than session.max_idle_time (%v)",
+			c.Session.PingInterval, c.Session.MaxIdleTime)
+	}
+	if c.Cleanup.Interval <= 0 {
+		add("cleanup.interval must be positive, got %v", c.Cleanup.Interval)
+	}
+	if c.Uploads.MaxSize <= 0 {
+		add("uploads.max_size must be positive, got %d", c.Uploads.MaxSize)
+	}
+	if c.Shutdown.Timeout <= 0 {
+		add("shutdown.timeout must be positive, got %v", c.Shutdown.Timeout)
+	}
+
+	if len(errs) > 0 {
+		return &Error{Problems: errs}
+	}
+	return nil
+}
+
+// Error lists every problem found while loading or validating the config.
+type Error struct {
+	Problems []error
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString("invalid configuration:")
+	for _, p := range e.Problems {
+		b.WriteString("\n  - ")
+		b.WriteString(p.Error())
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() []error {
+	return e.Problems
+}
+
+func lookupSetting(name string) setting {
+	for _, s := range settings {
+		if s.flag == name {
+			return s
+		}
+	}
+	panic("config: unknown setting " + name)
+}
+
+func setInt(dst *int, v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("not an integer: %q", v)
+	}
+	*dst = n
+	return nil
+}
+
+func setInt64(dst *int64, v string) error {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return fmt.Errorf("not an integer: %q", v)
+	}
+	*dst = n
+	return nil
+}
+
+func setBool(dst *bool, v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("not a boolean: %q", v)
+	}
+	*dst = b
+	return nil
+}
+
+func setDuration(dst *time.Duration, v string) error {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("not a duration: %q", v)
+	}
+	*dst = d
+	return nil
+}
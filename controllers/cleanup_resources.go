@@ -2,57 +2,54 @@ package controllers
 
 import (
 	"log"
-	"strings"
 	"temp0ral-chat/models"
-	"temp0ral-chat/utils"
+	"temp0ral-chat/store"
 	"time"
 )
 
-func StartPeriodicCleanup() {
-	ticker := time.NewTicker(models.CleanupInterval)
+// StartPeriodicCleanup runs the session and message cleanup every
+// Cleanup.Interval. The returned stop function halts the loop and blocks
+// until a cleanup pass that is already running has finished.
+func StartPeriodicCleanup() (stop func()) {
+	ticker := time.NewTicker(models.App.Cleanup.Interval)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+
 	go func() {
-		for range ticker.C {
-			TerminateIdleSessions()
-
-			CleanupExpiredSessions()
-
-			BroadcastUserList()
-
-			activeUserIDs := ActiveIDs()
-			if len(activeUserIDs) > 0 {
-				placeholders := make([]string, len(activeUserIDs))
-				args := make([]interface{}, len(activeUserIDs))
-				for i, userID := range activeUserIDs {
-					placeholders[i] = "$" + string(rune('0'+i+1))
-					args[i] = userID
-				}
-
-				query := "SELECT image_path FROM messages WHERE user_id NOT IN (" + strings.Join(placeholders, ",") + ") AND image_path IS NOT NULL"
-				DeleteImagesForQuery(query, args...)
-
-				delQuery := "DELETE FROM messages WHERE user_id NOT IN (" + strings.Join(placeholders, ",") + ")"
-				result, err := utils.DB.Exec(delQuery, args...)
-				if err != nil {
-					log.Printf("Error cleaning up orphaned messages: %v", err)
-				} else {
-					rowsAffected, _ := result.RowsAffected()
-					if rowsAffected > 0 {
-						log.Printf("Cleaned up %d orphaned messages", rowsAffected)
-					}
-				}
-			} else {
-				DeleteImagesForQuery("SELECT image_path FROM messages WHERE image_path IS NOT NULL")
-
-				result, err := utils.DB.Exec("DELETE FROM messages")
-				if err != nil {
-					log.Printf("Error clearing all messages: %v", err)
-				} else {
-					rowsAffected, _ := result.RowsAffected()
-					if rowsAffected > 0 {
-						log.Printf("Cleared all messages due to no active sessions: %d messages", rowsAffected)
-					}
-				}
+		defer close(done)
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				runCleanup()
 			}
 		}
 	}()
+
+	return func() {
+		ticker.Stop()
+		close(quit)
+		<-done
+	}
+}
+
+func runCleanup() {
+	TerminateIdleSessions()
+
+	CleanupExpiredSessions()
+
+	BroadcastUserLists()
+
+	DestroyAbandonedRooms()
+
+	activeUserIDs, asOf := ActiveIDs()
+	deleted, err := store.Messages.PruneSessions(activeUserIDs, asOf)
+	DeleteImages(deleted)
+	releasePins(deleted)
+	if err != nil {
+		log.Printf("Error pruning stale sessions: %v", err)
+	} else if len(deleted) > 0 {
+		log.Printf("Cleaned up %d orphaned messages", len(deleted))
+	}
 }
@@ -3,7 +3,6 @@ package controllers
 import (
 	"log"
 	"temp0ral-chat/models"
-	"temp0ral-chat/utils"
 	"time"
 )
 
@@ -28,32 +27,28 @@ func CleanupExpiredSessions() {
 		models.ActivityMutex.Lock()
 		for _, userID := range expiredUserIDs {
 			delete(models.UserLastActivity, userID)
+			delete(models.UserLastInteraction, userID)
 		}
 		models.ActivityMutex.Unlock()
 
+		purges.Add(1)
 		go func(userIDs []string) {
-			for _, userID := range userIDs {
-				DeleteImagesForQuery("SELECT image_path FROM messages WHERE user_id = $1 AND image_path IS NOT NULL", userID)
+			defer purges.Done()
 
-				_, err := utils.DB.Exec("DELETE FROM messages WHERE user_id = $1", userID)
-				if err != nil {
+			for _, userID := range userIDs {
+				if err := endStoredSession(userID); err != nil {
 					log.Printf("Error deleting messages for expired user %s: %v", userID, err)
 				} else {
 					log.Printf("Deleted messages for expired session user: %s", userID)
 				}
 			}
 
-			DeleteImagesForQuery("SELECT image_path FROM messages WHERE id NOT IN (SELECT id FROM messages ORDER BY created_at DESC LIMIT 500) AND image_path IS NOT NULL")
-
-			_, err := utils.DB.Exec("DELETE FROM messages WHERE id NOT IN (SELECT id FROM messages ORDER BY created_at DESC LIMIT 500)")
-			if err != nil {
-				log.Printf("Error during message cleanup: %v", err)
-			}
+			trimHistory()
 		}(expiredUserIDs)
 	}
 
 	if hadExpiredSessions {
-		BroadcastUserList()
+		BroadcastUserLists()
 	}
 }
 
@@ -69,7 +64,7 @@ func TerminateIdleSessions() {
 	for sessionID, session := range models.Sessions {
 		lastActivity, exists := models.UserLastActivity[session.UserID]
 
-		if !exists || now.Sub(lastActivity) > models.MaxIdleTime {
+		if !exists || now.Sub(lastActivity) > models.App.Session.MaxIdleTime {
 			expiredUserIDs = append(expiredUserIDs, session.UserID)
 			expiredSessionIDs = append(expiredSessionIDs, sessionID)
 			hadTerminations = true
@@ -90,30 +85,27 @@ func TerminateIdleSessions() {
 		models.ActivityMutex.Lock()
 		for _, userID := range expiredUserIDs {
 			delete(models.UserLastActivity, userID)
+			delete(models.UserLastInteraction, userID)
 		}
 		models.ActivityMutex.Unlock()
 
+		purges.Add(1)
 		go func(userIDs []string) {
-			for _, userID := range userIDs {
-				DeleteImagesForQuery("SELECT image_path FROM messages WHERE user_id = $1 AND image_path IS NOT NULL", userID)
+			defer purges.Done()
 
-				_, err := utils.DB.Exec("DELETE FROM messages WHERE user_id = $1", userID)
-				if err != nil {
+			for _, userID := range userIDs {
+				if err := endStoredSession(userID); err != nil {
 					log.Printf("Error deleting messages for idle-terminated user %s: %v", userID, err)
 				} else {
 					log.Printf("Deleted messages for idle-terminated user: %s", userID[:8])
 				}
 			}
 
-			DeleteImagesForQuery("SELECT image_path FROM messages WHERE id NOT IN (SELECT id FROM messages ORDER BY created_at DESC LIMIT 500) AND image_path IS NOT NULL")
-			_, err := utils.DB.Exec("DELETE FROM messages WHERE id NOT IN (SELECT id FROM messages ORDER BY created_at DESC LIMIT 500)")
-			if err != nil {
-				log.Printf("Error during message cleanup: %v", err)
-			}
+			trimHistory()
 		}(expiredUserIDs)
 	}
 
 	if hadTerminations {
-		BroadcastUserList()
+		BroadcastUserLists()
 	}
 }
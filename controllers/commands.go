@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"temp0ral-chat/helpers"
+	"temp0ral-chat/models"
+)
+
+// protocolVersion is the version of the inbound WebSocket command protocol.
+// Every frame must carry it as "v".
+const protocolVersion = 1
+
+// commandEnvelope is the part every inbound frame shares. The handler for
+// Type decodes the rest of the frame itself.
+//
+//	{"v":1,"type":"send","username":"anon","chat_message":"hi"}
+type commandEnvelope struct {
+	V    int    `json:"v"`
+	Type string `json:"type"`
+}
+
+// commandError is reported back to the client that sent the command.
+type commandError struct {
+	msg string
+}
+
+func (e *commandError) Error() string {
+	return e.msg
+}
+
+type commandHandler func(client *Client, session models.Session, frame []byte) error
+
+var commandHandlers = map[string]commandHandler{
+	"delete":    deleteCommand,
+	"dm":        dmCommand,
+	"edit":      editCommand,
+	"heartbeat": heartbeatCommand,
+	"pin":       pinCommand(true),
+	"ping":      pingCommand,
+	"react":     reactCommand,
+	"resume":    resumeCommand,
+	"send":      sendCommand,
+	"typing":    typingCommand,
+	"unpin":     pinCommand(false),
+}
+
+// dispatchCommand decodes an inbound frame and runs its handler, replying to
+// the client with an error fragment if anything goes wrong.
+func dispatchCommand(client *Client, session models.Session, frame []byte) {
+	var env commandEnvelope
+	if err := json.Unmarshal(frame, &env); err != nil {
+		client.hub.SendToClient(client, commandErrorHTML("invalid", "Malformed command"))
+		return
+	}
+	if env.V != protocolVersion {
+		client.hub.SendToClient(client, commandErrorHTML(env.Type,
+			fmt.Sprintf("Unsupported protocol version %d (expected %d)", env.V, protocolVersion)))
+		return
+	}
+
+	handler, ok := commandHandlers[env.Type]
+	if !ok {
+		client.hub.SendToClient(client, commandErrorHTML(env.Type, "Unknown command"))
+		return
+	}
+
+	if err := handler(client, session, frame); err != nil {
+		if _, ok := err.(*commandError); !ok {
+			log.Printf("Error handling %q command: %v", env.Type, err)
+			err = &commandError{"Something went wrong"}
+		}
+		client.hub.SendToClient(client, commandErrorHTML(env.Type, err.Error()))
+	}
+}
+
+// commandErrorHTML shows msg in #error-container. data-command names the
+// command that failed so non-browser clients can tell replies apart.
+func commandErrorHTML(command, msg string) string {
+	return `<div hx-swap-oob="innerHTML:#error-container">
+		<div class="error-message" data-command="` + html.EscapeString(command) + `">` +
+		html.EscapeString(msg) + `</div>
+	</div>`
+}
+
+func decodeCommand(frame []byte, v interface{}) error {
+	if err := json.Unmarshal(frame, v); err != nil {
+		return &commandError{"Malformed command"}
+	}
+	return nil
+}
+
+func heartbeatCommand(client *Client, session models.Session, frame []byte) error {
+	var cmd struct {
+		Focused bool `json:"focused"`
+	}
+	if err := decodeCommand(frame, &cmd); err != nil {
+		return err
+	}
+	if cmd.Focused {
+		helpers.UpdateUserInteraction(session.UserID)
+	}
+	return nil
+}
+
+func pingCommand(client *Client, session models.Session, frame []byte) error {
+	client.hub.SendToClient(client, `<div id="ws-pong" hx-swap-oob="true" hidden></div>`)
+	return nil
+}
+
+func sendCommand(client *Client, session models.Session, frame []byte) error {
+	var cmd struct {
+		Username    string `json:"username"`
+		ChatMessage string `json:"chat_message"`
+	}
+	if err := decodeCommand(frame, &cmd); err != nil {
+		return err
+	}
+
+	helpers.UpdateUserInteraction(session.UserID)
+
+	reply, msgErr := sendChat(client.room, session, cmd.Username, cmd.ChatMessage, "")
+	if msgErr != nil {
+		return &commandError{msgErr.msg}
+	}
+	if reply != "" {
+		client.hub.SendToClient(client, reply)
+	}
+	return nil
+}
+
+func resumeCommand(client *Client, session models.Session, frame []byte) error {
+	var cmd struct {
+		Seq int64 `json:"seq"`
+	}
+	if err := decodeCommand(frame, &cmd); err != nil {
+		return err
+	}
+	client.hub.Resume(client, cmd.Seq)
+	return nil
+}
+
+func deleteCommand(client *Client, session models.Session, frame []byte) error {
+	var cmd struct {
+		ID int `json:"id"`
+	}
+	if err := decodeCommand(frame, &cmd); err != nil {
+		return err
+	}
+	if msgErr := removeMessage(session, cmd.ID); msgErr != nil {
+		return &commandError{msgErr.msg}
+	}
+	return nil
+}
+
+func editCommand(client *Client, session models.Session, frame []byte) error {
+	var cmd struct {
+		ID          int    `json:"id"`
+		ChatMessage string `json:"chat_message"`
+	}
+	if err := decodeCommand(frame, &cmd); err != nil {
+		return err
+	}
+
+	helpers.UpdateUserInteraction(session.UserID)
+
+	if _, msgErr := editMessage(session, cmd.ID, cmd.ChatMessage); msgErr != nil {
+		return &commandError{msgErr.msg}
+	}
+	return nil
+}
+
+func pinCommand(pinned bool) commandHandler {
+	return func(client *Client, session models.Session, frame []byte) error {
+		var cmd struct {
+			ID int `json:"id"`
+		}
+		if err := decodeCommand(frame, &cmd); err != nil {
+			return err
+		}
+		if msgErr := pinMessage(session, cmd.ID, pinned); msgErr != nil {
+			return &commandError{msgErr.msg}
+		}
+		return nil
+	}
+}
+
+func dmCommand(client *Client, session models.Session, frame []byte) error {
+	var cmd struct {
+		UserID      string `json:"user_id"`
+		ChatMessage string `json:"chat_message"`
+	}
+	if err := decodeCommand(frame, &cmd); err != nil {
+		return err
+	}
+
+	helpers.UpdateUserInteraction(session.UserID)
+
+	if msgErr := sendDirectMessage(session, cmd.UserID, cmd.ChatMessage); msgErr != nil {
+		return &commandError{msgErr.msg}
+	}
+	return nil
+}
+
+func typingCommand(client *Client, session models.Session, frame []byte) error {
+	var cmd struct {
+		Typing bool `json:"typing"`
+	}
+	if err := decodeCommand(frame, &cmd); err != nil {
+		return err
+	}
+
+	if cmd.Typing {
+		helpers.UpdateUserInteraction(session.UserID)
+	}
+	client.hub.SetTyping(session.UserID, cmd.Typing)
+	return nil
+}
+
+func reactCommand(client *Client, session models.Session, frame []byte) error {
+	var cmd struct {
+		ID    int    `json:"id"`
+		Emoji string `json:"emoji"`
+	}
+	if err := decodeCommand(frame, &cmd); err != nil {
+		return err
+	}
+
+	helpers.UpdateUserInteraction(session.UserID)
+
+	if msgErr := toggleReaction(session, cmd.ID, cmd.Emoji); msgErr != nil {
+		return &commandError{msgErr.msg}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"temp0ral-chat/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateRoomHandler creates the room named in the "room" form field, or
+// finds the existing one, and sends the caller there. With "private" set it
+// creates a private room with a generated name instead. Guests of a private
+// room cannot create rooms.
+func CreateRoomHandler(c *gin.Context) {
+	userSession := c.MustGet("session").(models.Session)
+	if userSession.RoomID != "" {
+		c.String(http.StatusForbidden, "Guests cannot create rooms")
+		return
+	}
+
+	var room *Room
+	var err error
+	if c.PostForm("private") != "" {
+		room, err = CreatePrivateRoom(userSession)
+	} else {
+		room, err = CreateRoom(strings.ToLower(strings.TrimSpace(c.PostForm("room"))))
+	}
+	if errors.Is(err, ErrInvalidRoomID) {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Println("Create room error:", err)
+		c.String(http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/r/"+room.ID)
+}
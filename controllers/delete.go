@@ -1,33 +1,42 @@
 package controllers
 
 import (
-	"database/sql"
 	"log"
 	"os"
-	"temp0ral-chat/utils"
+	"temp0ral-chat/models"
+	"temp0ral-chat/store"
 )
 
-func DeleteImagesForQuery(query string, args ...interface{}) {
-	rows, err := utils.DB.Query(query, args...)
-	if err != nil {
-		log.Printf("Error querying images to delete: %v", err)
-		return
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var imagePath sql.NullString
-		if err := rows.Scan(&imagePath); err != nil {
-			log.Printf("Error scanning image path: %v", err)
+// DeleteImages removes the uploaded image files of messages that have been
+// deleted from the store.
+func DeleteImages(messages []models.Message) {
+	for _, msg := range messages {
+		if msg.ImagePath == "" {
 			continue
 		}
-		if imagePath.Valid && imagePath.String != "" {
-			err := os.Remove("." + imagePath.String)
-			if err != nil {
-				log.Printf("Error deleting image file %s: %v", imagePath.String, err)
-			} else {
-				log.Printf("Deleted image file: %s", imagePath.String)
-			}
+		err := os.Remove("." + msg.ImagePath)
+		if err != nil {
+			log.Printf("Error deleting image file %s: %v", msg.ImagePath, err)
+		} else {
+			log.Printf("Deleted image file: %s", msg.ImagePath)
 		}
 	}
 }
+
+// endStoredSession removes a departed user's session from the store, which
+// takes their messages and pins with it, and deletes their images.
+func endStoredSession(userID string) error {
+	deleted, err := store.Messages.EndSession(userID)
+	DeleteImages(deleted)
+	releasePins(deleted)
+	return err
+}
+
+// trimHistory enforces the message history cap.
+func trimHistory() {
+	deleted, err := store.Messages.TrimToLimit(models.App.Messages.HistoryLimit)
+	if err != nil {
+		log.Printf("Error during message cleanup: %v", err)
+	}
+	DeleteImages(deleted)
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"temp0ral-chat/models"
+	"temp0ral-chat/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func DeleteMessage(c *gin.Context) {
+	userSession := c.MustGet("session").(models.Session)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	if msgErr := removeMessage(userSession, id); msgErr != nil {
+		c.String(msgErr.status, msgErr.msg)
+		return
+	}
+
+	// hx-swap="outerHTML" on #msg-{id}: an empty body removes it.
+	c.String(http.StatusOK, "")
+}
+
+// removeMessage deletes a message its caller owns, or any message if the
+// caller is a moderator, and removes it from every client in its room.
+func removeMessage(userSession models.Session, id int) *messageError {
+	msg, err := store.Messages.Get(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return &messageError{http.StatusNotFound, "Message not found"}
+	}
+	if err != nil {
+		log.Println("Fetch message error:", err)
+		return &messageError{http.StatusInternalServerError, "Database error"}
+	}
+
+	room, err := EnterRoom(userSession, msg.RoomID)
+	if errors.Is(err, store.ErrRoomNotFound) {
+		return &messageError{http.StatusNotFound, "Message not found"}
+	}
+	if err != nil {
+		log.Println("Fetch room error:", err)
+		return &messageError{http.StatusInternalServerError, "Database error"}
+	}
+
+	if msg.UserID != userSession.UserID && !userSession.Moderator {
+		return &messageError{http.StatusForbidden, "You can only delete your own messages"}
+	}
+
+	deleted, err := store.Messages.Delete(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return &messageError{http.StatusNotFound, "Message not found"}
+	}
+	if err != nil {
+		log.Println("Delete message error:", err)
+		return &messageError{http.StatusInternalServerError, "Database error"}
+	}
+	DeleteImages([]models.Message{deleted})
+
+	if msg.UserID != userSession.UserID {
+		log.Printf("Moderator %s deleted message %d by %s", userSession.UserID[:8], id, msg.UserID[:8])
+	}
+
+	room.Hub.Publish(`<div id="msg-` + strconv.Itoa(id) + `" hx-swap-oob="delete"></div>`)
+	if deleted.Pinned() {
+		room.Hub.Publish(pinnedRemovedHTML(id))
+	}
+	publishBacklinks(room.Hub, msg.ReplyTo)
+	return nil
+}
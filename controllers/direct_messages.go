@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"temp0ral-chat/helpers"
+	"temp0ral-chat/models"
+	"temp0ral-chat/store"
+	"temp0ral-chat/templates"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DirectConversation renders the DM pane for the conversation with the user
+// in :userID.
+func DirectConversation(c *gin.Context) {
+	userSession := c.MustGet("session").(models.Session)
+
+	peer, msgErr := directPeer(userSession, c.Param("userID"))
+	if msgErr != nil {
+		c.String(msgErr.status, msgErr.msg)
+		return
+	}
+
+	dms, err := store.Messages.Conversation(userSession.UserID, peer.UserID, models.App.Messages.HistoryLimit)
+	if err != nil {
+		log.Println("Fetch conversation error:", err)
+		c.String(http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	c.Header("Content-Type", "text/html")
+	templates.DirectConversation(peer.UserID, dms, helpers.Viewer(userSession)).Render(c.Request.Context(), c.Writer)
+}
+
+// SendDirectMessage sends the chat_message field to the user in :userID.
+// Both parties get it over their WebSockets, so the response is empty.
+func SendDirectMessage(c *gin.Context) {
+	userSession := c.MustGet("session").(models.Session)
+
+	helpers.UpdateUserInteraction(userSession.UserID)
+
+	if msgErr := sendDirectMessage(userSession, c.Param("userID"), c.PostForm("chat_message")); msgErr != nil {
+		c.String(msgErr.status, msgErr.msg)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// sendDirectMessage stores a direct message and delivers it to every
+// connection of its sender and recipient, and no one else.
+func sendDirectMessage(userSession models.Session, peerID, content string) *messageError {
+	peer, msgErr := directPeer(userSession, peerID)
+	if msgErr != nil {
+		return msgErr
+	}
+
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return &messageError{http.StatusBadRequest, "Message cannot be empty"}
+	}
+
+	dm, err := store.Messages.InsertDirect(models.DirectMessage{
+		SenderID:    userSession.UserID,
+		RecipientID: peer.UserID,
+		Content:     content,
+	})
+	if errors.Is(err, store.ErrNoSession) {
+		return &messageError{http.StatusNotFound, "User is no longer online"}
+	}
+	if err != nil {
+		log.Println("Insert direct message error:", err)
+		return &messageError{http.StatusInternalServerError, "Database error"}
+	}
+
+	trimHistory()
+
+	for _, session := range []models.Session{userSession, peer} {
+		var buf strings.Builder
+		if err := templates.DirectMessageNotice(dm, helpers.Viewer(session)).Render(context.Background(), &buf); err != nil {
+			log.Println("Render error:", err)
+			return &messageError{http.StatusInternalServerError, "Render error"}
+		}
+		for _, room := range RunningRooms() {
+			room.Hub.SendToUser(session.UserID, buf.String())
+		}
+	}
+	return nil
+}
+
+// directPeer returns the live session of peerID if userSession may message
+// it. Guests of a private room can only talk to people who may enter it,
+// and the other way round.
+func directPeer(userSession models.Session, peerID string) (models.Session, *messageError) {
+	if peerID == userSession.UserID {
+		return models.Session{}, &messageError{http.StatusBadRequest, "You cannot message yourself"}
+	}
+
+	peer, ok := helpers.GetActiveSession(peerID)
+	if !ok {
+		return models.Session{}, &messageError{http.StatusNotFound, "User is no longer online"}
+	}
+
+	for _, session := range []models.Session{userSession, peer} {
+		if session.RoomID == "" {
+			continue
+		}
+		room, err := GetRoom(session.RoomID)
+		if err != nil || !room.Admits(userSession) || !room.Admits(peer) {
+			return models.Session{}, &messageError{http.StatusForbidden, "You cannot message this user"}
+		}
+	}
+	return peer, nil
+}
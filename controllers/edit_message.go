@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"temp0ral-chat/helpers"
+	"temp0ral-chat/models"
+	"temp0ral-chat/store"
+	"temp0ral-chat/templates"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EditMessage replaces the content of one of the caller's messages. The new
+// text comes from the chat_message field or, for the edit button's
+// hx-prompt, the HX-Prompt header.
+func EditMessage(c *gin.Context) {
+	userSession := c.MustGet("session").(models.Session)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	content, ok := c.GetPostForm("chat_message")
+	if !ok {
+		content = c.GetHeader("HX-Prompt")
+	}
+
+	helpers.UpdateUserInteraction(userSession.UserID)
+
+	msgHTML, msgErr := editMessage(userSession, id, content)
+	if msgErr != nil {
+		c.String(msgErr.status, msgErr.msg)
+		return
+	}
+
+	c.Header("Content-Type", "text/html")
+	c.String(http.StatusOK, msgHTML)
+}
+
+// editMessage stores the new content, keeping the old as a revision, and
+// replaces #msg-{id} on every client in its room. References and mentions
+// are parsed again, updating backlinks and notifying newly mentioned users.
+// It returns the message re-rendered for the editor.
+func editMessage(userSession models.Session, id int, content string) (string, *messageError) {
+	window := models.App.Messages.EditWindow
+	if window == 0 {
+		return "", &messageError{http.StatusForbidden, "Editing is disabled"}
+	}
+
+	msg, err := store.Messages.Get(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", &messageError{http.StatusNotFound, "Message not found"}
+	}
+	if err != nil {
+		log.Println("Fetch message error:", err)
+		return "", &messageError{http.StatusInternalServerError, "Database error"}
+	}
+
+	room, err := EnterRoom(userSession, msg.RoomID)
+	if errors.Is(err, store.ErrRoomNotFound) {
+		return "", &messageError{http.StatusNotFound, "Message not found"}
+	}
+	if err != nil {
+		log.Println("Fetch room error:", err)
+		return "", &messageError{http.StatusInternalServerError, "Database error"}
+	}
+
+	if msg.UserID != userSession.UserID {
+		return "", &messageError{http.StatusForbidden, "You can only edit your own messages"}
+	}
+	if msg.Kind == models.KindSystem {
+		return "", &messageError{http.StatusForbidden, "System messages cannot be edited"}
+	}
+	if time.Since(msg.CreatedAt) > window {
+		return "", &messageError{http.StatusForbidden, "This message can no longer be edited"}
+	}
+
+	content = strings.TrimSpace(content)
+	if content == "" && msg.ImagePath == "" {
+		return "", &messageError{http.StatusBadRequest, "Message cannot be empty"}
+	}
+
+	if content != msg.Content {
+		old := msg
+		msg, err = store.Messages.Edit(id, content,
+			templates.PostReferences(content), helpers.ResolveMentions(templates.Mentions(content)))
+		if errors.Is(err, store.ErrNotFound) {
+			return "", &messageError{http.StatusNotFound, "Message not found"}
+		}
+		if err != nil {
+			log.Println("Edit message error:", err)
+			return "", &messageError{http.StatusInternalServerError, "Database error"}
+		}
+
+		room.Hub.PublishEvent(Event{Type: EventEdit, Message: msg})
+		// Both the posts it stopped quoting and those it quotes now list it.
+		publishBacklinks(room.Hub, union(old.ReplyTo, msg.ReplyTo))
+		// Only those mentioned for the first time are notified.
+		notice := msg
+		notice.Mentions = slices.DeleteFunc(slices.Clone(msg.Mentions), func(userID string) bool {
+			return slices.Contains(old.Mentions, userID)
+		})
+		notifyMentioned(room.Hub, notice)
+	}
+
+	var buf strings.Builder
+	if err := templates.Message(msg, helpers.Viewer(userSession)).Render(context.Background(), &buf); err != nil {
+		log.Println("Render error:", err)
+		return "", &messageError{http.StatusInternalServerError, "Render error"}
+	}
+	return buf.String(), nil
+}
+
+// MessageRevisions renders the edit history shown under an edited message.
+func MessageRevisions(c *gin.Context) {
+	userSession := c.MustGet("session").(models.Session)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	msg, err := store.Messages.Get(id)
+	if err == nil {
+		_, err = EnterRoom(userSession, msg.RoomID)
+	}
+	if errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrRoomNotFound) {
+		c.String(http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		log.Println("Fetch message error:", err)
+		c.String(http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	revisions, err := store.Messages.Revisions(id)
+	if err != nil {
+		log.Println("Fetch revisions error:", err)
+		c.String(http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	c.Header("Content-Type", "text/html")
+	templates.Revisions(revisions).Render(c.Request.Context(), c.Writer)
+}
+
+// union returns the IDs in a or b, each once.
+func union(a, b []int) []int {
+	ids := slices.Concat(a, b)
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"strings"
+	"temp0ral-chat/helpers"
+	"temp0ral-chat/models"
+	"temp0ral-chat/templates"
+)
+
+// EventType says what a message event does to the page.
+type EventType string
+
+const (
+	// EventMessage appends a new message to #messages.
+	EventMessage EventType = "message"
+	// EventEdit replaces the copy of an edited message already on the page.
+	EventEdit EventType = "edit"
+	// EventReactions replaces the reaction bar of a message.
+	EventReactions EventType = "reactions"
+	// EventPin replaces a message that was pinned or unpinned and adds it
+	// to, or removes it from, the pinned-messages panel.
+	EventPin EventType = "pin"
+)
+
+// Event is a published change to one message. Rather than sharing one HTML
+// string, it is rendered for each recipient by their writer goroutine, so
+// controls and styling follow the viewer.
+type Event struct {
+	Type    EventType
+	Message models.Message
+}
+
+// render returns the fragment for ev as client's user should see it, or ""
+// if it cannot be rendered.
+func (c *Client) render(ev Event) string {
+	viewer := helpers.Viewer(c.session)
+
+	var buf strings.Builder
+	var err error
+	switch ev.Type {
+	case EventMessage:
+		buf.WriteString(`<div hx-swap-oob="beforeend:#messages">`)
+		err = templates.Message(ev.Message, viewer).Render(context.Background(), &buf)
+		buf.WriteString(`</div>`)
+	case EventEdit:
+		err = templates.MessageSwap(ev.Message, viewer).Render(context.Background(), &buf)
+		if err == nil && ev.Message.Pinned() {
+			err = templates.PinnedSwap(ev.Message, viewer, false).Render(context.Background(), &buf)
+		}
+	case EventReactions:
+		err = templates.ReactionsSwap(ev.Message, viewer).Render(context.Background(), &buf)
+	case EventPin:
+		err = templates.MessageSwap(ev.Message, viewer).Render(context.Background(), &buf)
+		if err == nil {
+			err = templates.PinnedSwap(ev.Message, viewer, ev.Message.Pinned()).Render(context.Background(), &buf)
+		}
+	default:
+		log.Printf("Unknown event type %q", ev.Type)
+		return ""
+	}
+	if err != nil {
+		log.Printf("Render error for %s event: %v", ev.Type, err)
+		return ""
+	}
+	return buf.String()
+}
@@ -4,7 +4,6 @@ import (
 	"log"
 	"net/http"
 	"temp0ral-chat/models"
-	"temp0ral-chat/utils"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,20 +17,21 @@ func Logout(c *gin.Context) {
 
 	models.ActivityMutex.Lock()
 	delete(models.UserLastActivity, session.UserID)
+	delete(models.UserLastInteraction, session.UserID)
 	models.ActivityMutex.Unlock()
 
+	purges.Add(1)
 	go func(userID string) {
-		DeleteImagesForQuery("SELECT image_path FROM messages WHERE user_id = $1 AND image_path IS NOT NULL", userID)
+		defer purges.Done()
 
-		_, err := utils.DB.Exec("DELETE FROM messages WHERE user_id = $1", userID)
-		if err != nil {
+		if err := endStoredSession(userID); err != nil {
 			log.Printf("Error deleting messages on logout: %v", err)
 		} else {
 			log.Printf("Deleted messages for user on logout: %s", userID)
 		}
 	}(session.UserID)
 
-	BroadcastUserList()
+	BroadcastUserLists()
 
 	c.SetCookie("session_id", "", -1, "/", "", false, true)
 	c.Redirect(http.StatusFound, "/")
@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"html"
+	"strconv"
+	"temp0ral-chat/models"
+)
+
+// notifyMentioned tells each user @mentioned in msg, and only them, so
+// their browser can raise a notification. The message itself has already
+// been published to hub, so it is on the page when the notice arrives. Users
+// who are not in the room are not told.
+func notifyMentioned(hub *Hub, msg models.Message) {
+	for _, userID := range msg.Mentions {
+		if userID == msg.UserID {
+			continue
+		}
+		hub.SendToUser(userID, mentionNoticeHTML(msg))
+	}
+}
+
+// mentionNoticeHTML is swapped into the hidden #ws-mention element, where
+// chat.js picks it up.
+func mentionNoticeHTML(msg models.Message) string {
+	return `<div id="ws-mention" hx-swap-oob="true" hidden` +
+		` data-message-id="` + strconv.Itoa(msg.ID) + `"` +
+		` data-username="` + html.EscapeString(msg.Username) + `"` +
+		` data-content="` + html.EscapeString(msg.Content) + `"></div>`
+}
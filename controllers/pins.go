@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"temp0ral-chat/models"
+	"temp0ral-chat/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PinMessage pins a message to its room's pinned-messages panel.
+func PinMessage(c *gin.Context) {
+	pinMessageHandler(c, true)
+}
+
+// UnpinMessage takes a message off its room's pinned-messages panel.
+func UnpinMessage(c *gin.Context) {
+	pinMessageHandler(c, false)
+}
+
+func pinMessageHandler(c *gin.Context, pinned bool) {
+	userSession := c.MustGet("session").(models.Session)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	if msgErr := pinMessage(userSession, id, pinned); msgErr != nil {
+		c.String(msgErr.status, msgErr.msg)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// pinMessage pins or unpins a message for a moderator and updates every
+// client in its room. Pinned messages outlive the history limit, but not
+// their author's session.
+func pinMessage(userSession models.Session, id int, pinned bool) *messageError {
+	if !userSession.Moderator {
+		return &messageError{http.StatusForbidden, "Only moderators can pin messages"}
+	}
+
+	msg, err := store.Messages.Visible(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return &messageError{http.StatusNotFound, "Message not found"}
+	}
+	if err != nil {
+		log.Println("Fetch message error:", err)
+		return &messageError{http.StatusInternalServerError, "Database error"}
+	}
+
+	room, err := EnterRoom(userSession, msg.RoomID)
+	if errors.Is(err, store.ErrRoomNotFound) {
+		return &messageError{http.StatusNotFound, "Message not found"}
+	}
+	if err != nil {
+		log.Println("Fetch room error:", err)
+		return &messageError{http.StatusInternalServerError, "Database error"}
+	}
+
+	if msg.Pinned() == pinned {
+		return nil
+	}
+
+	msg, err = store.Messages.Pin(id, pinned)
+	if errors.Is(err, store.ErrNotFound) {
+		return &messageError{http.StatusNotFound, "Message not found"}
+	}
+	if err != nil {
+		log.Println("Pin message error:", err)
+		return &messageError{http.StatusInternalServerError, "Database error"}
+	}
+
+	room.Hub.PublishEvent(Event{Type: EventPin, Message: msg})
+	return nil
+}
+
+// releasePins removes the deleted messages that were pinned from the
+// pinned-messages panel of their rooms.
+func releasePins(deleted []models.Message) {
+	for _, msg := range deleted {
+		if !msg.Pinned() {
+			continue
+		}
+		roomsMutex.Lock()
+		room := rooms[msg.RoomID]
+		roomsMutex.Unlock()
+		if room != nil {
+			room.Hub.Publish(pinnedRemovedHTML(msg.ID))
+		}
+	}
+}
+
+func pinnedRemovedHTML(id int) string {
+	return `<div id="pinned-` + strconv.Itoa(id) + `" hx-swap-oob="delete"></div>`
+}
@@ -1,18 +1,19 @@
 package controllers
 
 import (
-	"context"
-	"database/sql"
+	"errors"
+	"fmt"
 	"image"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"path/filepath"
 	"strings"
 	"temp0ral-chat/helpers"
 	"temp0ral-chat/models"
+	"temp0ral-chat/store"
 	"temp0ral-chat/templates"
-	"temp0ral-chat/utils"
 
 	"github.com/gin-gonic/gin"
 )
@@ -20,43 +21,50 @@ import (
 func SendMessage(c *gin.Context) {
 	sessionAny, exists := c.Get("session")
 	if !exists {
-		c.String(http.StatusUnauthorized, "Unauthorized")
+		sendError(c, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 	userSession := sessionAny.(models.Session)
 
 	if _, valid := GetSession(userSession.ID); !valid {
-		c.String(http.StatusUnauthorized, "Session expired")
+		sendError(c, http.StatusUnauthorized, "Session expired")
 		return
 	}
 
-	helpers.UpdateUserActivity(userSession.UserID)
+	helpers.UpdateUserInteraction(userSession.UserID)
 
-	username := c.PostForm("username")
-	if username == "" {
-		username = "Anon"
+	room, err := EnterRoom(userSession, c.Param("room"))
+	if errors.Is(err, store.ErrRoomNotFound) {
+		sendError(c, http.StatusNotFound, "Room not found")
+		return
+	}
+	if err != nil {
+		log.Println("Fetch room error:", err)
+		sendError(c, http.StatusInternalServerError, "Database error")
+		return
 	}
 
+	username := c.PostForm("username")
 	chatMsg := c.PostForm("chat_message")
 
 	var imagePath string
 	file, err := c.FormFile("image")
 	if err == nil {
-		if file.Size > models.MaxUploadSize {
-			c.String(http.StatusBadRequest, "File too large (max 5MB)")
+		if maxSize := models.App.Uploads.MaxSize; file.Size > maxSize {
+			sendError(c, http.StatusBadRequest, "File too large (max "+formatSize(maxSize)+")")
 			return
 		}
 
 		f, err := file.Open()
 		if err != nil {
-			c.String(http.StatusInternalServerError, "Error opening file")
+			sendError(c, http.StatusInternalServerError, "Error opening file")
 			return
 		}
 		defer f.Close()
 
 		_, _, err = image.DecodeConfig(f)
 		if err != nil {
-			c.String(http.StatusBadRequest, "Invalid image format")
+			sendError(c, http.StatusBadRequest, "Invalid image format")
 			return
 		}
 
@@ -71,77 +79,140 @@ func SendMessage(c *gin.Context) {
 
 		if err := c.SaveUploadedFile(file, "."+imagePath); err != nil {
 			log.Println("Save file error:", err)
-			c.String(http.StatusInternalServerError, "Error saving file")
+			sendError(c, http.StatusInternalServerError, "Error saving file")
 			return
 		}
 	}
 
-	if chatMsg == "" && imagePath == "" {
-		c.String(http.StatusBadRequest, "Message cannot be empty")
+	reply, msgErr := sendChat(room, userSession, username, chatMsg, imagePath)
+	if msgErr != nil {
+		DeleteImages([]models.Message{{ImagePath: imagePath}})
+		sendError(c, msgErr.status, msgErr.msg)
 		return
 	}
 
-	var newID int
-	var dbImagePath interface{}
-	if imagePath != "" {
-		dbImagePath = imagePath
-	} else {
-		dbImagePath = nil
-	}
+	clearResponse := `
+		<input id="message-input" name="chat_message" placeholder="Type your message..." autocomplete="off" value="" hx-swap-oob="true">
+		<input type="file" id="file-input" name="image" accept="image/*" style="display: none;" hx-swap-oob="true">
+		<div id="file-preview" hx-swap-oob="outerHTML"></div>
+		<div id="emoji-picker" hx-swap-oob="innerHTML"></div>
+	`
 
-	err = utils.DB.QueryRow(
-		"INSERT INTO messages (username, content, user_id, image_path) VALUES ($1, $2, $3, $4) RETURNING id",
-		username, chatMsg, userSession.UserID, dbImagePath,
-	).Scan(&newID)
-	if err != nil {
-		log.Println("Insert error:", err)
-		c.String(http.StatusInternalServerError, "Database error")
-		return
+	c.Header("Content-Type", "text/html")
+	c.String(http.StatusOK, reply+clearResponse)
+}
+
+// sendError answers the send-message form with msg in #error-container,
+// which the form's htmx:beforeSwap handler swaps in despite the status.
+func sendError(c *gin.Context, status int, msg string) {
+	c.Header("Content-Type", "text/html")
+	c.String(status, commandErrorHTML("send", msg))
+}
+
+// messageError is a failure to post a message, reported to the sender as an
+// HTTP status or a WebSocket error reply.
+type messageError struct {
+	status int
+	msg    string
+}
+
+// sendChat posts what a user typed into a room. Text starting with "/" runs
+// a slash command instead, and "//" escapes a leading slash. It returns HTML
+// that only the sender should see, if any. It is shared by the send-message
+// form and the WebSocket "send" command.
+func sendChat(room *Room, userSession models.Session, username, chatMsg, imagePath string) (string, *messageError) {
+	if strings.HasPrefix(chatMsg, "/") && !strings.HasPrefix(chatMsg, "//") {
+		return runSlashCommand(slashContext{
+			room:      room,
+			session:   userSession,
+			username:  username,
+			imagePath: imagePath,
+		}, chatMsg)
 	}
 
-	DeleteImagesForQuery("SELECT image_path FROM messages WHERE id NOT IN (SELECT id FROM messages ORDER BY created_at DESC LIMIT 500) AND image_path IS NOT NULL")
+	if strings.HasPrefix(chatMsg, "//") {
+		chatMsg = chatMsg[1:]
+	}
+	_, msgErr := publishMessage(room, userSession, models.Message{
+		Username:  username,
+		Content:   chatMsg,
+		ImagePath: imagePath,
+	})
+	return "", msgErr
+}
 
-	_, err = utils.DB.Exec("DELETE FROM messages WHERE id NOT IN (SELECT id FROM messages ORDER BY created_at DESC LIMIT 500)")
-	if err != nil {
-		log.Println("Cleanup error:", err)
+// publishMessage validates, stores and broadcasts msg to a room on behalf of
+// userSession. Only Kind, Username, Content and ImagePath are taken from
+// msg; a missing username falls back to the session's nickname.
+func publishMessage(room *Room, userSession models.Session, msg models.Message) (models.Message, *messageError) {
+	if msg.Username == "" {
+		msg.Username = displayName(userSession)
 	}
 
-	var newMsg models.Message
-	var imgPath sql.NullString
-	err = utils.DB.QueryRow("SELECT id, username, content, created_at, user_id, image_path FROM messages WHERE id = $1", newID).Scan(
-		&newMsg.ID, &newMsg.Username, &newMsg.Content, &newMsg.CreatedAt, &newMsg.UserID, &imgPath,
-	)
-	if err != nil {
-		log.Println("Fetch new message error:", err)
-		c.String(http.StatusInternalServerError, "Database error")
-		return
+	if msg.Content == "" && msg.ImagePath == "" {
+		return models.Message{}, &messageError{http.StatusBadRequest, "Message cannot be empty"}
 	}
-	if imgPath.Valid {
-		newMsg.ImagePath = imgPath.String
+
+	var replyTo []int
+	var mentions []string
+	if msg.Kind != models.KindSystem {
+		replyTo = templates.PostReferences(msg.Content)
+		mentions = helpers.ResolveMentions(templates.Mentions(msg.Content))
 	}
 
-	component := templates.Message(newMsg)
-	ctx := context.Background()
-	var buf strings.Builder
-	if err := component.Render(ctx, &buf); err != nil {
-		log.Println("Render error:", err)
-		c.String(http.StatusInternalServerError, "Render error")
-		return
+	newMsg, err := store.Messages.Insert(models.Message{
+		RoomID:    room.ID,
+		Kind:      msg.Kind,
+		Username:  msg.Username,
+		Content:   msg.Content,
+		UserID:    userSession.UserID,
+		ImagePath: msg.ImagePath,
+		ReplyTo:   replyTo,
+		Mentions:  mentions,
+	})
+	if errors.Is(err, store.ErrRoomNotFound) {
+		// The room was destroyed after the caller entered it.
+		return models.Message{}, &messageError{http.StatusNotFound, "Room not found"}
+	}
+	if err != nil {
+		log.Println("Insert error:", err)
+		return models.Message{}, &messageError{http.StatusInternalServerError, "Database error"}
 	}
-	msgHTML := buf.String()
 
-	broadcastHTML := `<div hx-swap-oob="beforeend:#messages">` + msgHTML + `</div>`
-	GlobalHub.broadcast <- broadcastHTML
+	trimHistory()
 
-	BroadcastUserList()
+	room.Hub.SetTyping(userSession.UserID, false)
+	room.Hub.PublishEvent(Event{Type: EventMessage, Message: newMsg})
+	publishBacklinks(room.Hub, newMsg.ReplyTo)
+	notifyMentioned(room.Hub, newMsg)
 
-	clearResponse := `
-		<input id="message-input" name="chat_message" placeholder="Type your message..." autocomplete="off" value="" hx-swap-oob="true">
-		<input type="file" id="file-input" name="image" accept="image/*" style="display: none;" hx-swap-oob="true">
-		<div id="file-preview" hx-swap-oob="outerHTML"></div>
-		<div id="emoji-picker" hx-swap-oob="innerHTML"></div>
-	`
+	BroadcastUserList(room)
 
-	c.Header("Content-Type", "text/html")
-	c.String(http.StatusOK, clearResponse)
+	return newMsg, nil
+}
+
+// displayName is the name a session's messages go by when they have no
+// username: its /nick, or "Anon". The session is looked up again, as
+// WebSocket clients hold the copy they connected with.
+func displayName(userSession models.Session) string {
+	if current, ok := GetSession(userSession.ID); ok && current.Nickname != "" {
+		return current.Nickname
+	}
+	return "Anon"
+}
+
+// formatSize describes a size in bytes for people, e.g. "5MB" or "512KB",
+// with one decimal place when it is not a whole number of units.
+func formatSize(bytes int64) string {
+	size, unit := float64(bytes), "B"
+	for _, next := range []string{"KB", "MB", "GB"} {
+		if size < 1024 {
+			break
+		}
+		size, unit = size/1024, next
+	}
+	if size == math.Trunc(size) {
+		return fmt.Sprintf("%.0f%s", size, unit)
+	}
+	return fmt.Sprintf("%.1f%s", size, unit)
 }
@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"net/http"
+	"temp0ral-chat/models"
+	"temp0ral-chat/store"
+	"testing"
+)
+
+func TestPublishToDestroyedRoom(t *testing.T) {
+	room, err := CreateRoom("post-destroyed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := CreateSession(false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The room goes away after the sender entered it but before the insert.
+	if _, err := store.Messages.DeleteRoom(room.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	_, msgErr := publishMessage(room, session, models.Message{Content: "anyone?"})
+	if msgErr == nil || msgErr.status != http.StatusNotFound || msgErr.msg != "Room not found" {
+		t.Errorf("publishMessage = %+v, want 404 Room not found", msgErr)
+	}
+}
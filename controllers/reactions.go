@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"temp0ral-chat/helpers"
+	"temp0ral-chat/models"
+	"temp0ral-chat/store"
+	"temp0ral-chat/templates"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxReactionLength is the longest reaction, in characters. The kaomojis
+// in the picker all fit.
+const maxReactionLength = 32
+
+// ReactionPicker renders the choice of reactions for a message.
+func ReactionPicker(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	c.Header("Content-Type", "text/html")
+	templates.ReactionPicker(id).Render(c.Request.Context(), c.Writer)
+}
+
+// ToggleReaction adds the caller's reaction in the emoji field to a message,
+// or takes it back if they already reacted with it. Every client gets the
+// new reaction bar over its WebSocket, so the response is empty.
+func ToggleReaction(c *gin.Context) {
+	userSession := c.MustGet("session").(models.Session)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	helpers.UpdateUserInteraction(userSession.UserID)
+
+	if msgErr := toggleReaction(userSession, id, c.PostForm("emoji")); msgErr != nil {
+		c.String(msgErr.status, msgErr.msg)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func toggleReaction(userSession models.Session, id int, emoji string) *messageError {
+	emoji = strings.TrimSpace(emoji)
+	if emoji == "" || utf8.RuneCountInString(emoji) > maxReactionLength ||
+		strings.ContainsFunc(emoji, unicode.IsControl) {
+		return &messageError{http.StatusBadRequest, "Reactions must be 1-32 characters on one line"}
+	}
+
+	msg, err := store.Messages.Visible(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return &messageError{http.StatusNotFound, "Message not found"}
+	}
+	if err != nil {
+		log.Println("Fetch message error:", err)
+		return &messageError{http.StatusInternalServerError, "Database error"}
+	}
+
+	room, err := EnterRoom(userSession, msg.RoomID)
+	if errors.Is(err, store.ErrRoomNotFound) {
+		return &messageError{http.StatusNotFound, "Message not found"}
+	}
+	if err != nil {
+		log.Println("Fetch room error:", err)
+		return &messageError{http.StatusInternalServerError, "Database error"}
+	}
+
+	msg, err = store.Messages.ToggleReaction(id, userSession.UserID, emoji)
+	if errors.Is(err, store.ErrNotFound) {
+		return &messageError{http.StatusNotFound, "Message not found"}
+	}
+	if err != nil {
+		log.Println("Toggle reaction error:", err)
+		return &messageError{http.StatusInternalServerError, "Database error"}
+	}
+
+	room.Hub.PublishEvent(Event{Type: EventReactions, Message: msg})
+	return nil
+}
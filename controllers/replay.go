@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"strconv"
+	"strings"
+	"temp0ral-chat/models"
+)
+
+// event is part of a frame queued for a client. Like a delivery, it holds
+// either fixed html or an Event to render per client; replayable broadcasts
+// also have their sequence number, and are kept as events for replay.
+type event struct {
+	seq   int64
+	html  string
+	event *Event
+}
+
+type resumeRequest struct {
+	client *Client
+	since  int64
+}
+
+// reloadHTML tells a resuming client that the events it missed are no longer
+// available and it has to reload the page instead.
+const reloadHTML = `<div id="ws-seq" hx-swap-oob="true" data-reload="true" hidden></div>`
+
+// SeqMarkerHTML records the last sequence number a page has seen. Every
+// replayable event carries one, and chat.js sends it back on reconnect.
+func SeqMarkerHTML(seq int64) string {
+	return `<div id="ws-seq" hx-swap-oob="true" data-seq="` + strconv.FormatInt(seq, 10) + `" hidden></div>`
+}
+
+// Seq returns the sequence number of the latest replayable event.
+func (h *Hub) Seq() int64 {
+	return h.seq.Load()
+}
+
+// Resume replays to client every event published after since, or asks it to
+// reload if some of them have already been dropped.
+func (h *Hub) Resume(client *Client, since int64) {
+	toHub(h, h.resume, resumeRequest{client: client, since: since})
+}
+
+// record numbers d and appends it to the history, which keeps as many
+// events as the message history limit. Must only be called from RunSocket.
+func (h *Hub) record(d delivery) int64 {
+	seq := h.seq.Add(1)
+
+	h.history = append(h.history, event{seq: seq, html: d.msg, event: d.event})
+	if excess := len(h.history) - models.App.Messages.HistoryLimit; excess > 0 {
+		h.replayFloor = h.history[excess-1].seq
+		h.history = append(h.history[:0:0], h.history[excess:]...)
+	}
+	return seq
+}
+
+// replay sends client the events it missed before it joined, as a single
+// frame so a long gap cannot overflow its send queue, then releases the
+// live events held back while it was resuming. Must only be called from
+// RunSocket.
+func (h *Hub) replay(client *Client, since int64) {
+	var missed []event
+	// A sequence number from the future was handed out by an earlier server
+	// process, so the client's page is stale as well.
+	if since < h.replayFloor || since > h.seq.Load() {
+		missed = append(missed, event{html: reloadHTML})
+	} else {
+		for _, ev := range h.history {
+			if ev.seq > since && ev.seq <= client.joinedSeq {
+				missed = append(missed, ev)
+			}
+		}
+	}
+
+	pending := client.pending
+	client.pending = nil
+	client.awaitingResume = false
+
+	if len(missed) > 0 {
+		h.deliver(client, missed...)
+	}
+	for _, parts := range pending {
+		if !h.clients[client] {
+			return
+		}
+		h.deliver(client, parts...)
+	}
+}
+
+// renderFrame renders the parts of a frame for c, each event followed by
+// its sequence marker. It runs on c's writer goroutine.
+func (c *Client) renderFrame(parts []event) string {
+	var frame strings.Builder
+	for _, part := range parts {
+		if part.event != nil {
+			frame.WriteString(c.render(*part.event))
+		} else {
+			frame.WriteString(part.html)
+		}
+		if part.seq > 0 {
+			frame.WriteString(SeqMarkerHTML(part.seq))
+		}
+	}
+	return frame.String()
+}
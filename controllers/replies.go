@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"strings"
+	"temp0ral-chat/store"
+	"temp0ral-chat/templates"
+)
+
+// publishBacklinks re-renders the "Replies:" line of each message in ids on
+// every client of hub, after a reply to them was posted or deleted.
+func publishBacklinks(hub *Hub, ids []int) {
+	var buf strings.Builder
+	for _, id := range ids {
+		msg, err := store.Messages.Get(id)
+		if err != nil {
+			// Gone since the reply was stored; nothing left to update.
+			continue
+		}
+		if err := templates.BacklinksSwap(msg).Render(context.Background(), &buf); err != nil {
+			log.Println("Render error:", err)
+			return
+		}
+	}
+	if buf.Len() > 0 {
+		hub.Publish(buf.String())
+	}
+}
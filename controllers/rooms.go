@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"slices"
+	"sync"
+	"temp0ral-chat/config"
+	"temp0ral-chat/helpers"
+	"temp0ral-chat/models"
+	"temp0ral-chat/store"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrInvalidRoomID = errors.New("room names are 1-32 lowercase letters, digits and dashes")
+
+// Room is a chat room with its own hub. Rooms live in the store; a Room is
+// started the first time anyone asks for it and runs until shutdown, or
+// until a private room is destroyed.
+type Room struct {
+	models.Room
+	Hub *Hub
+
+	membersMutex sync.RWMutex
+	// members counts the open WebSocket connections of each user in the
+	// room, so the user list only shows who is actually here.
+	members map[string]int
+}
+
+var (
+	roomsMutex sync.Mutex
+	rooms      = make(map[string]*Room)
+)
+
+// GetRoom returns the running room id, starting its hub if needed. It
+// returns store.ErrRoomNotFound for rooms that were never created.
+func GetRoom(id string) (*Room, error) {
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+	return getRoom(id)
+}
+
+// getRoom is GetRoom for callers holding roomsMutex.
+func getRoom(id string) (*Room, error) {
+	if room, ok := rooms[id]; ok {
+		return room, nil
+	}
+
+	stored, err := store.Messages.Room(id)
+	if err != nil {
+		return nil, err
+	}
+
+	room := &Room{
+		Room:    stored,
+		Hub:     NewHub(),
+		members: make(map[string]int),
+	}
+	go room.Hub.RunSocket()
+	rooms[id] = room
+	return room, nil
+}
+
+// CreateRoom creates and starts a new room. Creating a room that already
+// exists just returns it.
+func CreateRoom(id string) (*Room, error) {
+	if !config.ValidRoomID(id) {
+		return nil, ErrInvalidRoomID
+	}
+	if _, err := store.Messages.CreateRoom(models.Room{ID: id}); err != nil && !errors.Is(err, store.ErrRoomExists) {
+		return nil, err
+	}
+	return GetRoom(id)
+}
+
+// CreatePrivateRoom creates a private room with a generated name and access
+// key for the caller to hand out to guests.
+func CreatePrivateRoom(creator models.Session) (*Room, error) {
+	stored, err := store.Messages.CreateRoom(models.Room{
+		ID:        "p-" + helpers.GenerateID(6),
+		AccessKey: helpers.GenerateID(16),
+		CreatedBy: creator.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetRoom(stored.ID)
+}
+
+// AdmitGuest starts a session confined to the private room id for a guest
+// holding its access key, or returns store.ErrRoomNotFound if the room or
+// key is wrong. It holds roomsMutex like destroyRoom, so the room is either
+// gone before the key is checked or sees the guest's session and stays.
+func AdmitGuest(id, accessKey string) (models.Session, error) {
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+
+	room, err := getRoom(id)
+	if err != nil {
+		return models.Session{}, err
+	}
+	if !room.Private() || subtle.ConstantTimeCompare([]byte(accessKey), []byte(room.AccessKey)) != 1 {
+		return models.Session{}, store.ErrRoomNotFound
+	}
+	return CreateSession(false, room.ID)
+}
+
+// EnterRoom is GetRoom for session, reporting rooms it may not enter as
+// store.ErrRoomNotFound so private rooms cannot be discovered.
+func EnterRoom(session models.Session, id string) (*Room, error) {
+	room, err := GetRoom(id)
+	if err != nil {
+		return nil, err
+	}
+	if !room.Admits(session) {
+		return nil, store.ErrRoomNotFound
+	}
+	return room, nil
+}
+
+const roomClosedNoticeHTML = `<div hx-swap-oob="innerHTML:#error-container">
+	<div class="error-message">
+		This private room has been closed. Please <a href="/chat" class="error-link">go back to the lobby</a>.
+	</div>
+</div>`
+
+var roomClosedFrame = websocket.FormatCloseMessage(websocket.CloseGoingAway, "room closed")
+
+// DestroyAbandonedRooms deletes every private room that no active session
+// may enter any more, together with its messages and uploads.
+func DestroyAbandonedRooms() {
+	stored, err := store.Messages.Rooms()
+	if err != nil {
+		log.Printf("Error listing rooms: %v", err)
+		return
+	}
+
+	activeSessions := helpers.GetActiveSessions()
+	for _, room := range stored {
+		if !room.Private() || slices.ContainsFunc(activeSessions, room.Admits) {
+			continue
+		}
+		destroyed, err := destroyRoom(room.ID)
+		if err != nil {
+			log.Printf("Error destroying private room %s: %v", room.ID, err)
+		} else if destroyed {
+			log.Printf("Destroyed abandoned private room %s", room.ID)
+		}
+	}
+}
+
+// destroyRoom deletes a room from the store and stops its hub, unless an
+// active session may enter it. That is checked again under roomsMutex,
+// which AdmitGuest holds while a guest signs in, so no guest is left with
+// a session for a room that no longer exists.
+func destroyRoom(id string) (destroyed bool, err error) {
+	roomsMutex.Lock()
+	stored, err := store.Messages.Room(id)
+	if err != nil || slices.ContainsFunc(helpers.GetActiveSessions(), stored.Admits) {
+		roomsMutex.Unlock()
+		return false, err
+	}
+	deleted, err := store.Messages.DeleteRoom(id)
+	room := rooms[id]
+	if err == nil {
+		delete(rooms, id)
+	}
+	roomsMutex.Unlock()
+
+	DeleteImages(deleted)
+	if err != nil {
+		return false, err
+	}
+	if room != nil {
+		room.Hub.close(shutdownRequest{notice: roomClosedNoticeHTML, closeFrame: roomClosedFrame, stop: true})
+	}
+	return true, nil
+}
+
+// RunningRooms returns every room whose hub has been started.
+func RunningRooms() []*Room {
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+
+	running := make([]*Room, 0, len(rooms))
+	for _, room := range rooms {
+		running = append(running, room)
+	}
+	return running
+}
+
+// ShutdownRooms shuts down the hub of every running room.
+func ShutdownRooms() {
+	for _, room := range RunningRooms() {
+		room.Hub.Shutdown()
+	}
+}
+
+func (r *Room) join(userID string) {
+	r.membersMutex.Lock()
+	r.members[userID]++
+	r.membersMutex.Unlock()
+}
+
+func (r *Room) leave(userID string) {
+	r.membersMutex.Lock()
+	if r.members[userID]--; r.members[userID] <= 0 {
+		delete(r.members, userID)
+	}
+	r.membersMutex.Unlock()
+}
+
+// ActiveSessions returns the active sessions of the users connected to the
+// room.
+func (r *Room) ActiveSessions() []models.Session {
+	r.membersMutex.RLock()
+	defer r.membersMutex.RUnlock()
+
+	var sessions []models.Session
+	for _, session := range helpers.GetActiveSessions() {
+		if r.members[session.UserID] > 0 {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"errors"
+	"temp0ral-chat/models"
+	"temp0ral-chat/store"
+	"testing"
+)
+
+func TestDestroyRoomSparesAdmittedGuest(t *testing.T) {
+	// The creator's session is not live, so only the guest keeps the room.
+	room, err := CreatePrivateRoom(models.Session{UserID: "gone-creator"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := AdmitGuest(room.ID, "wrong key"); !errors.Is(err, store.ErrRoomNotFound) {
+		t.Errorf("AdmitGuest with a wrong key: err = %v, want ErrRoomNotFound", err)
+	}
+	guest, err := AdmitGuest(room.ID, room.AccessKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guest.RoomID != room.ID {
+		t.Errorf("guest RoomID = %q, want %q", guest.RoomID, room.ID)
+	}
+
+	// A sweep that listed the room as abandoned before the guest arrived
+	// finds them when it comes to destroy it.
+	if destroyed, err := destroyRoom(room.ID); destroyed || err != nil {
+		t.Fatalf("destroyRoom with a guest = %v, %v, want false, nil", destroyed, err)
+	}
+	if _, err := store.Messages.Room(room.ID); err != nil {
+		t.Fatalf("room gone after sparing it: %v", err)
+	}
+
+	models.SessionsMutex.Lock()
+	delete(models.Sessions, guest.ID)
+	models.SessionsMutex.Unlock()
+
+	if destroyed, err := destroyRoom(room.ID); !destroyed || err != nil {
+		t.Fatalf("destroyRoom once the guest left = %v, %v, want true, nil", destroyed, err)
+	}
+	if _, err := AdmitGuest(room.ID, room.AccessKey); !errors.Is(err, store.ErrRoomNotFound) {
+		t.Errorf("AdmitGuest to a destroyed room: err = %v, want ErrRoomNotFound", err)
+	}
+}
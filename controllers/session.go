@@ -3,27 +3,42 @@ package controllers
 import (
 	"temp0ral-chat/helpers"
 	"temp0ral-chat/models"
+	"temp0ral-chat/store"
 	"time"
 )
 
-func CreateSession() models.Session {
+// CreateSession starts a session. Guests of a private room pass its ID and
+// are kept inside it; everyone else passes "".
+func CreateSession(moderator bool, roomID string) (models.Session, error) {
 	sessionID := helpers.GenerateID(16)
 	userID := helpers.GenerateID(8)
 
 	session := models.Session{
 		ID:        sessionID,
 		UserID:    userID,
-		ExpiresAt: time.Now().Add(models.SessionDuration),
-		CreatedAt: time.Now(),
+		Moderator: moderator,
+		RoomID:    roomID,
 	}
 
+	// The session is live before the store knows it, and stamped under the
+	// same lock ActiveIDs reads with, so a cleanup pass either sees it or
+	// has an older cutoff and spares its row.
 	models.SessionsMutex.Lock()
+	session.CreatedAt = time.Now()
+	session.ExpiresAt = session.CreatedAt.Add(models.App.Session.Duration)
 	models.Sessions[sessionID] = session
 	models.SessionsMutex.Unlock()
 
-	helpers.UpdateUserActivity(userID)
+	if err := store.Messages.AddSession(session); err != nil {
+		models.SessionsMutex.Lock()
+		delete(models.Sessions, sessionID)
+		models.SessionsMutex.Unlock()
+		return models.Session{}, err
+	}
+
+	helpers.UpdateUserInteraction(userID)
 
-	return session
+	return session, nil
 }
 
 func GetSession(sessionID string) (models.Session, bool) {
@@ -41,3 +56,18 @@ func GetSession(sessionID string) (models.Session, bool) {
 
 	return session, true
 }
+
+// SetNickname sets the /nick of a session, or clears it if nickname is "".
+// It reports whether the session still exists.
+func SetNickname(sessionID, nickname string) bool {
+	models.SessionsMutex.Lock()
+	defer models.SessionsMutex.Unlock()
+
+	session, exists := models.Sessions[sessionID]
+	if !exists {
+		return false
+	}
+	session.Nickname = nickname
+	models.Sessions[sessionID] = session
+	return true
+}
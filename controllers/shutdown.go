@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"temp0ral-chat/store"
+
+	"github.com/gorilla/websocket"
+)
+
+var shuttingDown atomic.Bool
+
+// purges tracks the background goroutines that delete a departed user's
+// messages so shutdown can wait for them instead of killing them mid-delete.
+var purges sync.WaitGroup
+
+const restartNoticeHTML = `<div hx-swap-oob="innerHTML:#error-container">
+	<div class="error-message">
+		The server is restarting. Please <a href="/" class="error-link">refresh the page</a> in a moment.
+	</div>
+</div>`
+
+var restartCloseFrame = websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server restarting")
+
+// BeginShutdown stops the server from handing out new sessions and
+// WebSocket connections.
+func BeginShutdown() {
+	shuttingDown.Store(true)
+}
+
+func ShuttingDown() bool {
+	return shuttingDown.Load()
+}
+
+// WaitForPurges blocks until in-flight message purges finish or ctx expires.
+func WaitForPurges(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		purges.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type shutdownRequest struct {
+	notice     string
+	closeFrame []byte
+	// stop makes RunSocket return once the clients are closed, for a hub
+	// whose room is gone.
+	stop bool
+	done chan struct{}
+}
+
+// Shutdown tells every connected client that the server is restarting,
+// closes its socket with a proper close frame and waits for the writers to
+// finish. Clients that connect afterwards are turned away.
+func (h *Hub) Shutdown() {
+	h.close(shutdownRequest{notice: restartNoticeHTML, closeFrame: restartCloseFrame})
+}
+
+// close sends every client req.notice, closes its socket with
+// req.closeFrame and waits for the writers to finish.
+func (h *Hub) close(req shutdownRequest) {
+	req.done = make(chan struct{})
+	if toHub(h, h.shutdown, req) {
+		<-req.done
+	}
+	h.writers.Wait()
+}
+
+// PurgeAll deletes every message and every file in ./uploads, so nothing
+// from an ephemeral chat survives a restart.
+func PurgeAll() {
+	deleted, err := store.Messages.DeleteAll()
+	if err != nil {
+		log.Printf("Error purging messages on shutdown: %v", err)
+	} else {
+		log.Printf("Purged %d messages on shutdown", len(deleted))
+	}
+
+	entries, err := os.ReadDir("./uploads")
+	if err != nil {
+		log.Printf("Error reading uploads directory: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join("./uploads", entry.Name())); err != nil {
+			log.Printf("Error deleting upload %s: %v", entry.Name(), err)
+		}
+	}
+	log.Printf("Purged %d uploads on shutdown", len(entries))
+}
@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"temp0ral-chat/models"
+	"temp0ral-chat/templates"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/a-h/templ"
+)
+
+// maxNicknameLength is the longest /nick, in characters.
+const maxNicknameLength = 32
+
+// Dice /roll accepts, as in 2d6: up to maxDice dice of up to maxDieSides.
+const (
+	maxDice     = 20
+	maxDieSides = 1000
+)
+
+var dicePattern = regexp.MustCompile(`^(\d*)d(\d+)$`)
+
+// slashContext is who ran a slash command, and where.
+type slashContext struct {
+	room      *Room
+	session   models.Session
+	username  string
+	imagePath string
+}
+
+// slashCommand is run when a chat message is "/name args". A command
+// either broadcasts a message to the room, returns a reply only the caller
+// sees, or fails with an error shown in #error-container.
+type slashCommand struct {
+	usage string // Arguments, for /help
+	help  string
+	run   func(ctx slashContext, args string) (string, *messageError)
+}
+
+var slashCommands map[string]slashCommand
+
+func init() {
+	slashCommands = map[string]slashCommand{
+		"clear": {"", "clear the chat on your screen", clearSlashCommand},
+		"flip":  {"", "flip a coin", flipSlashCommand},
+		"help":  {"", "list the commands", helpSlashCommand},
+		"me":    {"<action>", "say what you are doing", meSlashCommand},
+		"nick":  {"[name]", "go by name when you leave the username empty", nickSlashCommand},
+		"roll":  {"[NdM]", "roll N M-sided dice, 1d6 by default", rollSlashCommand},
+		"who":   {"", "list who is in the room", whoSlashCommand},
+	}
+}
+
+// runSlashCommand runs the slash command in text, which starts with "/".
+func runSlashCommand(ctx slashContext, text string) (string, *messageError) {
+	name, args, _ := strings.Cut(strings.TrimPrefix(text, "/"), " ")
+	name = strings.ToLower(name)
+	args = strings.TrimSpace(args)
+
+	cmd, ok := slashCommands[name]
+	if !ok {
+		return "", &messageError{http.StatusBadRequest, "Unknown command /" + name + ", see /help"}
+	}
+	if ctx.imagePath != "" && name != "me" {
+		return "", &messageError{http.StatusBadRequest, "Only /me can have an image attached"}
+	}
+	return cmd.run(ctx, args)
+}
+
+func meSlashCommand(ctx slashContext, args string) (string, *messageError) {
+	if args == "" && ctx.imagePath == "" {
+		return "", &messageError{http.StatusBadRequest, "Usage: /me <action>"}
+	}
+	_, msgErr := publishMessage(ctx.room, ctx.session, models.Message{
+		Kind:      models.KindAction,
+		Username:  ctx.username,
+		Content:   args,
+		ImagePath: ctx.imagePath,
+	})
+	return "", msgErr
+}
+
+func nickSlashCommand(ctx slashContext, args string) (string, *messageError) {
+	if utf8.RuneCountInString(args) > maxNicknameLength || strings.ContainsFunc(args, unicode.IsControl) {
+		return "", &messageError{http.StatusBadRequest, "Nicknames must be at most 32 characters on one line"}
+	}
+	if !SetNickname(ctx.session.ID, args) {
+		return "", &messageError{http.StatusUnauthorized, "Session expired"}
+	}
+
+	line := "You are now known as " + args
+	if args == "" {
+		line = "Nickname cleared, you are Anon again"
+	}
+	return renderReply("nick", templates.CommandReply("nick", []string{line}), templates.UsernameInputSwap(args)), nil
+}
+
+func rollSlashCommand(ctx slashContext, args string) (string, *messageError) {
+	if args == "" {
+		args = "1d6"
+	}
+	match := dicePattern.FindStringSubmatch(strings.ToLower(args))
+	if match == nil {
+		return "", &messageError{http.StatusBadRequest, "Usage: /roll NdM, e.g. /roll 2d6"}
+	}
+	count := 1
+	if match[1] != "" {
+		count, _ = strconv.Atoi(match[1])
+	}
+	sides, _ := strconv.Atoi(match[2])
+	if count < 1 || count > maxDice || sides < 2 || sides > maxDieSides {
+		return "", &messageError{http.StatusBadRequest,
+			fmt.Sprintf("You can roll 1-%d dice with 2-%d sides", maxDice, maxDieSides)}
+	}
+
+	rolls := make([]string, count)
+	total := 0
+	for i := range rolls {
+		roll := rand.IntN(sides) + 1
+		rolls[i] = strconv.Itoa(roll)
+		total += roll
+	}
+	result := fmt.Sprintf("rolled %dd%d: %d", count, sides, total)
+	if count > 1 {
+		result = fmt.Sprintf("rolled %dd%d: %s = %d", count, sides, strings.Join(rolls, " + "), total)
+	}
+	return "", systemMessage(ctx, "🎲 "+result)
+}
+
+func flipSlashCommand(ctx slashContext, args string) (string, *messageError) {
+	side := "heads"
+	if rand.IntN(2) == 1 {
+		side = "tails"
+	}
+	return "", systemMessage(ctx, "🪙 flipped a coin: "+side)
+}
+
+// systemMessage broadcasts the outcome of a command as a system message,
+// which nobody can type or edit.
+func systemMessage(ctx slashContext, content string) *messageError {
+	_, msgErr := publishMessage(ctx.room, ctx.session, models.Message{
+		Kind:     models.KindSystem,
+		Username: ctx.username,
+		Content:  content,
+	})
+	return msgErr
+}
+
+// clearSlashCommand empties #messages for the caller only. Reloading the
+// page brings the history back.
+func clearSlashCommand(ctx slashContext, args string) (string, *messageError) {
+	return `<div id="messages" hx-swap-oob="innerHTML"></div>`, nil
+}
+
+func whoSlashCommand(ctx slashContext, args string) (string, *messageError) {
+	sessions := ctx.room.ActiveSessions()
+	lines := []string{fmt.Sprintf("%d online in #%s:", len(sessions), ctx.room.ID)}
+	for _, session := range sessions {
+		line := session.UserID[:8]
+		if session.Nickname != "" {
+			line += " (" + session.Nickname + ")"
+		}
+		lines = append(lines, line)
+	}
+	return renderReply("who", templates.CommandReply("who", lines)), nil
+}
+
+func helpSlashCommand(ctx slashContext, args string) (string, *messageError) {
+	names := make([]string, 0, len(slashCommands))
+	for name := range slashCommands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	lines := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		cmd := slashCommands[name]
+		usage := "/" + name
+		if cmd.usage != "" {
+			usage += " " + cmd.usage
+		}
+		lines = append(lines, usage+": "+cmd.help)
+	}
+	lines = append(lines, "Start a message with // to post it with a leading /")
+	return renderReply("help", templates.CommandReply("help", lines)), nil
+}
+
+// renderReply renders the fragments of a command's reply, or "" if one
+// fails.
+func renderReply(command string, components ...templ.Component) string {
+	var buf strings.Builder
+	for _, component := range components {
+		if err := component.Render(context.Background(), &buf); err != nil {
+			log.Printf("Render error for /%s: %v", command, err)
+			return ""
+		}
+	}
+	return buf.String()
+}
@@ -1,54 +1,52 @@
 package controllers
 
 import (
-	"temp0ral-chat/helpers"
 	"temp0ral-chat/models"
 	"time"
 )
 
-func GetState() map[string]interface{} {
+// GetState summarises the activity of the users in a room.
+func GetState(room *Room) map[string]interface{} {
+	activeSessions := room.ActiveSessions()
+
 	models.ActivityMutex.RLock()
 	defer models.ActivityMutex.RUnlock()
 
 	stats := map[string]interface{}{
+		"room":           room.ID,
 		"total":          0,
 		"online":         0,
 		"idle":           0,
 		"near_timeout":   0,
-		"idle_threshold": models.IdleThreshold.String(),
-		"max_idle_time":  models.MaxIdleTime.String(),
+		"idle_threshold": models.App.Session.IdleThreshold.String(),
+		"max_idle_time":  models.App.Session.MaxIdleTime.String(),
 	}
 
-	activeSessions := helpers.GetActiveSessions()
 	stats["total"] = len(activeSessions)
 
 	now := time.Now()
-	nearTimeoutThreshold := models.MaxIdleTime - (2 * time.Minute)
+	nearTimeoutThreshold := models.App.Session.MaxIdleTime - (2 * time.Minute)
 
 	for _, session := range activeSessions {
-		lastActivity, exists := models.UserLastActivity[session.UserID]
-		if !exists {
+		lastInteraction, exists := models.UserLastInteraction[session.UserID]
+		if !exists || now.Sub(lastInteraction) <= models.App.Session.IdleThreshold {
 			stats["online"] = stats["online"].(int) + 1
 			continue
 		}
 
-		timeSinceActivity := now.Sub(lastActivity)
-
-		if timeSinceActivity > models.IdleThreshold {
-			stats["idle"] = stats["idle"].(int) + 1
+		stats["idle"] = stats["idle"].(int) + 1
 
-			if timeSinceActivity > nearTimeoutThreshold {
-				stats["near_timeout"] = stats["near_timeout"].(int) + 1
-			}
-		} else {
-			stats["online"] = stats["online"].(int) + 1
+		if lastActivity, ok := models.UserLastActivity[session.UserID]; ok && now.Sub(lastActivity) > nearTimeoutThreshold {
+			stats["near_timeout"] = stats["near_timeout"].(int) + 1
 		}
 	}
 
 	return stats
 }
 
-func ActiveIDs() []string {
+// ActiveIDs returns the users with an unexpired session and the time they
+// were read at. Sessions created after that time are not listed.
+func ActiveIDs() ([]string, time.Time) {
 	models.SessionsMutex.RLock()
 	defer models.SessionsMutex.RUnlock()
 
@@ -61,5 +59,5 @@ func ActiveIDs() []string {
 		}
 	}
 
-	return activeUserIDs
+	return activeUserIDs, now
 }
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"html"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// typingTimeout is how long a "typing" frame counts for. Clients repeat
+	// it while the user keeps typing, so a tab that closes mid-sentence
+	// drops out on its own.
+	typingTimeout = 5 * time.Second
+	// maxNamedTypers is how many typers the indicator names before it
+	// falls back to "Several people".
+	maxNamedTypers = 3
+)
+
+type typingUpdate struct {
+	userID string
+	typing bool
+}
+
+// SetTyping records that userID started or stopped typing in the hub's room.
+func (h *Hub) SetTyping(userID string, typing bool) {
+	toHub(h, h.typingUpdates, typingUpdate{userID: userID, typing: typing})
+}
+
+// updateTyping applies u and reports whether the set of typers changed.
+// Must only be called from RunSocket.
+func (h *Hub) updateTyping(u typingUpdate) bool {
+	_, wasTyping := h.typing[u.userID]
+	if u.typing {
+		h.typing[u.userID] = time.Now().Add(typingTimeout)
+		return !wasTyping
+	}
+	delete(h.typing, u.userID)
+	return wasTyping
+}
+
+// expireTyping drops typers whose last frame has timed out and reports
+// whether there were any. Must only be called from RunSocket.
+func (h *Hub) expireTyping(now time.Time) bool {
+	expired := false
+	for userID, expiresAt := range h.typing {
+		if now.After(expiresAt) {
+			delete(h.typing, userID)
+			expired = true
+		}
+	}
+	return expired
+}
+
+// stopTypingOnLeave drops client's user from the typers once their last
+// connection to the room is gone and reports whether they were typing.
+// Must only be called from RunSocket, after client has been removed.
+func (h *Hub) stopTypingOnLeave(client *Client) bool {
+	userID := client.session.UserID
+	if _, ok := h.typing[userID]; !ok {
+		return false
+	}
+	for other := range h.clients {
+		if other.session.UserID == userID {
+			return false
+		}
+	}
+	delete(h.typing, userID)
+	return true
+}
+
+// sendTyping sends client the typing indicator as it should see it, without
+// itself, unless that is what it already shows. Must only be called from
+// RunSocket.
+func (h *Hub) sendTyping(client *Client) {
+	var typers []string
+	for userID := range h.typing {
+		if userID != client.session.UserID {
+			typers = append(typers, userID)
+		}
+	}
+	indicator := typingIndicatorHTML(typers)
+	if indicator == client.typingShown {
+		return
+	}
+	client.typingShown = indicator
+	h.deliver(client, event{html: indicator})
+}
+
+// broadcastTyping sends every client its typing indicator. Must only be
+// called from RunSocket.
+func (h *Hub) broadcastTyping() {
+	for client := range h.clients {
+		h.sendTyping(client)
+	}
+}
+
+// typingIndicatorHTML replaces the region under #messages, e.g. with
+// "abc12345 and def67890 are typing…".
+func typingIndicatorHTML(userIDs []string) string {
+	slices.Sort(userIDs)
+
+	names := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		names[i] = html.EscapeString(userID[:8])
+	}
+
+	var text string
+	switch {
+	case len(names) == 0:
+	case len(names) == 1:
+		text = names[0] + " is typing…"
+	case len(names) <= maxNamedTypers:
+		text = strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1] + " are typing…"
+	default:
+		text = "Several people are typing…"
+	}
+	return `<div id="typing-indicator" class="typing-indicator" hx-swap-oob="true">` + text + `</div>`
+}
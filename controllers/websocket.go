@@ -1,36 +1,116 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"temp0ral-chat/helpers"
 	"temp0ral-chat/models"
+	"temp0ral-chat/store"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// sendQueueSize bounds how far a client may fall behind before the hub
+	// evicts it rather than letting it hold up everyone else.
+	sendQueueSize = 64
+	writeWait     = 10 * time.Second
+	maxFrameSize  = 64 * 1024
+)
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
+// Client is one WebSocket connection. Only its writer goroutine writes to
+// conn; everyone else hands it frames through send. The writer also renders
+// the events in them, so the hub never waits for templates.
 type Client struct {
-	conn  *websocket.Conn
-	mutex sync.Mutex
+	room    *Room
+	hub     *Hub
+	conn    *websocket.Conn
+	send    chan []event
+	session models.Session
+
+	// closeFrame is sent by the writer once send is closed. It is set by the
+	// hub goroutine before it closes send.
+	closeFrame []byte
+
+	// Replay state, owned by the hub goroutine. A client that connects with
+	// ?resume=1 promises a "resume" command; until it arrives, live events
+	// are held in pending so replayed ones can go out first and in order.
+	awaitingResume bool
+	pending        [][]event
+	joinedSeq      int64
+
+	// typingShown is the typing indicator last sent to the client, owned
+	// by the hub goroutine.
+	typingShown string
 }
 
+// Hub fans messages out to the clients of one room. The clients map is owned by the
+// RunSocket goroutine; everything else talks to it through channels, so no
+// lock is ever held across a network write.
 type Hub struct {
-	clients   map[*Client]bool
-	broadcast chan string
-	mutex     sync.Mutex
+	clients    map[*Client]bool
+	broadcast  chan delivery
+	register   chan *Client
+	unregister chan *Client
+	resume     chan resumeRequest
+	shutdown   chan shutdownRequest
+	// typing maps the users composing a message to when that expires.
+	typing        map[string]time.Time
+	typingUpdates chan typingUpdate
+	// closeFrame is set once the hub has shut down; clients that connect
+	// afterwards are closed with it straight away.
+	closeFrame []byte
+	// stopped is closed when RunSocket returns, after the hub's room was
+	// destroyed. Whatever is still sent to the hub is dropped from then on.
+	stopped chan struct{}
+
+	// seq numbers replayable events. It is only advanced by the hub
+	// goroutine but read by page renders, hence atomic.
+	seq atomic.Int64
+	// history holds the most recent replayable events, oldest first, and
+	// replayFloor the newest sequence number that has fallen out of it.
+	history     []event
+	replayFloor int64
+
+	// writers tracks the per-client writer goroutines so Shutdown can wait
+	// for close frames to go out.
+	writers sync.WaitGroup
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		clients:    make(map[*Client]bool),
+		broadcast:  make(chan delivery),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		resume:     make(chan resumeRequest),
+		shutdown:   make(chan shutdownRequest),
+		stopped:    make(chan struct{}),
+
+		typing:        make(map[string]time.Time),
+		typingUpdates: make(chan typingUpdate),
+	}
 }
 
-var GlobalHub = Hub{
-	clients:   make(map[*Client]bool),
-	broadcast: make(chan string),
+// delivery is a message for the clients matching to, or for all clients when
+// to is nil. It is either a fixed msg or an event rendered per client.
+// Replayable deliveries are numbered and kept for clients that reconnect
+// after missing them.
+type delivery struct {
+	to         func(*Client) bool
+	msg        string
+	event      *Event
+	replayable bool
 }
 
 func WebSocketHandler(c *gin.Context) {
@@ -42,6 +122,22 @@ func WebSocketHandler(c *gin.Context) {
 
 	userSession := session.(models.Session)
 
+	if ShuttingDown() {
+		c.String(http.StatusServiceUnavailable, "Server restarting")
+		return
+	}
+
+	room, err := EnterRoom(userSession, c.Param("room"))
+	if errors.Is(err, store.ErrRoomNotFound) {
+		c.String(http.StatusNotFound, "Room not found")
+		return
+	}
+	if err != nil {
+		log.Println("Fetch room error:", err)
+		c.String(http.StatusInternalServerError, "Database error")
+		return
+	}
+
 	helpers.UpdateUserActivity(userSession.UserID)
 
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
@@ -50,27 +146,49 @@ func WebSocketHandler(c *gin.Context) {
 		return
 	}
 
-	client := &Client{conn: conn}
-	GlobalHub.mutex.Lock()
-	GlobalHub.clients[client] = true
-	GlobalHub.mutex.Unlock()
+	client := &Client{
+		room:           room,
+		hub:            room.Hub,
+		conn:           conn,
+		send:           make(chan []event, sendQueueSize),
+		session:        userSession,
+		closeFrame:     websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		awaitingResume: c.Query("resume") == "1",
+	}
+	room.Hub.writers.Add(1)
+	go client.writePump()
+	if !toHub(room.Hub, room.Hub.register, client) {
+		// The room was destroyed while the client connected.
+		client.closeFrame = room.Hub.closeFrame
+		close(client.send)
+		return
+	}
+	room.join(userSession.UserID)
 
-	BroadcastUserList()
+	BroadcastUserList(room)
+
+	// A connection that answers neither pings nor sends anything for
+	// MaxIdleTime is gone; the read error below then unregisters it.
+	maxIdle := models.App.Session.MaxIdleTime
+	conn.SetReadLimit(maxFrameSize)
+	conn.SetReadDeadline(time.Now().Add(maxIdle))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(maxIdle))
+		helpers.UpdateUserActivity(userSession.UserID)
+		return nil
+	})
 
 	for {
 		_, messageBytes, err := conn.ReadMessage()
 		if err != nil {
-			client.mutex.Lock()
-			client.conn.Close()
-			client.mutex.Unlock()
-			GlobalHub.mutex.Lock()
-			delete(GlobalHub.clients, client)
-			GlobalHub.mutex.Unlock()
-			BroadcastUserList()
+			toHub(room.Hub, room.Hub.unregister, client)
+			room.leave(userSession.UserID)
+			BroadcastUserList(room)
 			return
 		}
 
-		_ = messageBytes
+		conn.SetReadDeadline(time.Now().Add(maxIdle))
+		helpers.UpdateUserActivity(userSession.UserID)
 
 		if _, valid := GetSession(userSession.ID); !valid {
 			errorHTML := `<div hx-swap-oob="innerHTML:#error-container">
@@ -79,24 +197,51 @@ func WebSocketHandler(c *gin.Context) {
 				</div>
 			</div>`
 
-			client.mutex.Lock()
-			err := client.conn.WriteMessage(websocket.TextMessage, []byte(errorHTML))
-			client.mutex.Unlock()
-			if err != nil {
-				log.Println("Error sending session expired message:", err)
+			room.Hub.SendToClient(client, errorHTML)
+			toHub(room.Hub, room.Hub.unregister, client)
+			room.leave(userSession.UserID)
+			return
+		}
+
+		dispatchCommand(client, userSession, messageBytes)
+	}
+}
+
+// writePump renders queued frames and writes them to the connection, and
+// pings it every PingInterval. It exits when send is closed, after writing
+// the close frame, or on the first write error.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(models.App.Session.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+		c.hub.writers.Done()
+	}()
+
+	for {
+		select {
+		case frame, ok := <-c.send:
+			if !ok {
+				c.conn.WriteControl(websocket.CloseMessage, c.closeFrame, time.Now().Add(writeWait))
+				return
+			}
+			msg := c.renderFrame(frame)
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+				return
 			}
 
-			time.Sleep(100 * time.Millisecond)
-			client.mutex.Lock()
-			client.conn.Close()
-			client.mutex.Unlock()
-			return
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
 		}
 	}
 }
 
-func BroadcastUserList() {
-	activeSessions := helpers.GetActiveSessions()
+// BroadcastUserList sends the room's user list to everyone in it.
+func BroadcastUserList(room *Room) {
+	activeSessions := room.ActiveSessions()
 
 	userListHTML := `<div hx-swap-oob="innerHTML:#user-list">`
 	for _, session := range activeSessions {
@@ -104,7 +249,8 @@ func BroadcastUserList() {
 		status := helpers.GetUserStatus(session.UserID)
 		statusClass := "user-status-" + status
 
-		userListHTML += `<div class="user-item" title="Session ID: ` + session.UserID + `">
+		userListHTML += `<div class="user-item" title="Session ID: ` + session.UserID + ` (click to send a direct message)"
+			data-user-id="` + session.UserID + `" hx-get="/dm/` + session.UserID + `" hx-target="#dm-pane">
 			<span class="user-status ` + statusClass + `"></span>
 			<span class="user-id">` + shortID + `</span>
 		</div>`
@@ -114,37 +260,155 @@ func BroadcastUserList() {
 	countHTML := `<div hx-swap-oob="innerHTML:.user-count">` +
 		fmt.Sprintf("%d online", len(activeSessions)) + `</div>`
 
-	fullUpdate := userListHTML + countHTML
+	room.Hub.Broadcast(userListHTML + countHTML)
+}
 
-	GlobalHub.mutex.Lock()
-	for client := range GlobalHub.clients {
-		client.mutex.Lock()
-		err := client.conn.WriteMessage(websocket.TextMessage, []byte(fullUpdate))
-		client.mutex.Unlock()
-		if err != nil {
-			client.conn.Close()
-			GlobalHub.mutex.Lock()
-			delete(GlobalHub.clients, client)
-			GlobalHub.mutex.Unlock()
-		}
+// BroadcastUserLists refreshes the user list of every running room, for
+// when sessions end.
+func BroadcastUserLists() {
+	for _, room := range RunningRooms() {
+		BroadcastUserList(room)
 	}
-	GlobalHub.mutex.Unlock()
 }
 
+// Broadcast queues msg for every connected client. Use it for state that is
+// re-sent in full anyway, like the user list; use Publish for events a
+// reconnecting client must not miss.
+func (h *Hub) Broadcast(msg string) {
+	toHub(h, h.broadcast, delivery{msg: msg})
+}
+
+// Publish numbers msg, remembers it for replay and queues it for every
+// connected client.
+func (h *Hub) Publish(msg string) {
+	toHub(h, h.broadcast, delivery{msg: msg, replayable: true})
+}
+
+// PublishEvent is Publish for an event, which is rendered separately for
+// each client.
+func (h *Hub) PublishEvent(ev Event) {
+	toHub(h, h.broadcast, delivery{event: &ev, replayable: true})
+}
+
+// SendToClient queues msg for a single client, if it is still connected.
+func (h *Hub) SendToClient(client *Client, msg string) {
+	toHub(h, h.broadcast, delivery{to: func(c *Client) bool { return c == client }, msg: msg})
+}
+
+// SendToUser queues msg for every connection of userID, e.g. all their
+// open tabs.
+func (h *Hub) SendToUser(userID, msg string) {
+	toHub(h, h.broadcast, delivery{to: func(c *Client) bool { return c.session.UserID == userID }, msg: msg})
+}
+
+// toHub hands v to the RunSocket goroutine of h through ch. It reports
+// false, dropping v, if the hub has stopped.
+func toHub[T any](h *Hub, ch chan T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	case <-h.stopped:
+		return false
+	}
+}
+
+// RunSocket runs the hub until a close request asks it to stop.
 func (h *Hub) RunSocket() {
-	for msg := range h.broadcast {
-		h.mutex.Lock()
-		for client := range h.clients {
-			client.mutex.Lock()
-			err := client.conn.WriteMessage(websocket.TextMessage, []byte(msg))
-			client.mutex.Unlock()
-			if err != nil {
-				client.conn.Close()
-				h.mutex.Lock()
-				delete(h.clients, client)
-				h.mutex.Unlock()
+	typingExpiry := time.NewTicker(time.Second)
+	defer typingExpiry.Stop()
+
+	for {
+		select {
+		case client := <-h.register:
+			if h.closeFrame != nil {
+				client.closeFrame = h.closeFrame
+				close(client.send)
+				continue
+			}
+			client.joinedSeq = h.seq.Load()
+			h.clients[client] = true
+			// The page starts out with nobody typing.
+			client.typingShown = typingIndicatorHTML(nil)
+			h.sendTyping(client)
+
+		case client := <-h.unregister:
+			if h.clients[client] {
+				h.removeClient(client)
+				if h.stopTypingOnLeave(client) {
+					h.broadcastTyping()
+				}
+			}
+
+		case u := <-h.typingUpdates:
+			if h.closeFrame == nil && h.updateTyping(u) {
+				h.broadcastTyping()
+			}
+
+		case now := <-typingExpiry.C:
+			if h.expireTyping(now) {
+				h.broadcastTyping()
+			}
+
+		case d := <-h.broadcast:
+			part := event{html: d.msg, event: d.event}
+			if d.replayable {
+				part.seq = h.record(d)
+			}
+			for client := range h.clients {
+				if d.to == nil || d.to(client) {
+					h.deliver(client, part)
+				}
+			}
+
+		case r := <-h.resume:
+			if h.clients[r.client] {
+				h.replay(r.client, r.since)
+			}
+
+		case req := <-h.shutdown:
+			h.closeFrame = req.closeFrame
+			for client := range h.clients {
+				select {
+				case client.send <- []event{{html: req.notice}}:
+				default:
+				}
+				client.closeFrame = req.closeFrame
+				h.removeClient(client)
+			}
+			close(req.done)
+			if req.stop {
+				close(h.stopped)
+				return
 			}
 		}
-		h.mutex.Unlock()
 	}
 }
+
+// deliver queues a frame made of parts for client, holding it back while
+// the client is still waiting to resume and evicting the client if it has
+// fallen too far behind. Like removeClient, it must only be called from the
+// RunSocket goroutine.
+func (h *Hub) deliver(client *Client, parts ...event) {
+	if client.awaitingResume {
+		if len(client.pending) < sendQueueSize {
+			client.pending = append(client.pending, parts)
+			return
+		}
+	} else {
+		select {
+		case client.send <- parts:
+			return
+		default:
+		}
+	}
+
+	log.Println("Evicting WebSocket client with a full send queue")
+	client.closeFrame = websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "client too slow")
+	h.removeClient(client)
+}
+
+// removeClient must only be called from the RunSocket goroutine.
+func (h *Hub) removeClient(client *Client) {
+	delete(h.clients, client)
+	close(client.send)
+}
@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"temp0ral-chat/models"
+	"temp0ral-chat/store"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// testTimeout bounds every wait in these tests.
+const testTimeout = 5 * time.Second
+
+// bigFrame is broadcast to fill the socket buffers of a client that stops
+// reading, so its writer blocks and its send queue backs up.
+var bigFrame = strings.Repeat("x", 256<<10)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	store.Messages = store.NewMemory(models.App.Messages.HistoryLimit)
+	os.Exit(m.Run())
+}
+
+// startTestRoom creates room id and serves its WebSocket for the session
+// named by ?session=. The room's hub is stopped when the test ends.
+func startTestRoom(t *testing.T, id string) (*Room, *httptest.Server) {
+	t.Helper()
+	room, err := CreateRoom(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.GET("/ws/:room", func(c *gin.Context) {
+		if session, ok := GetSession(c.Query("session")); ok {
+			c.Set("session", session)
+		}
+		WebSocketHandler(c)
+	})
+	srv := httptest.NewServer(router)
+	t.Cleanup(func() {
+		srv.Close()
+		onHub(t, func() { room.Hub.close(shutdownRequest{closeFrame: roomClosedFrame, stop: true}) })
+	})
+	return room, srv
+}
+
+// dialTestClient connects a new session to room and returns once the hub
+// has registered it and the user list naming the session went out.
+func dialTestClient(t *testing.T, srv *httptest.Server, room *Room) (*websocket.Conn, models.Session) {
+	t.Helper()
+	conn, session := dialTestSocket(t, srv, room)
+	readUntil(t, conn, `data-user-id="`+session.UserID+`"`)
+	return conn, session
+}
+
+// dialTestSocket connects a new session to room.
+func dialTestSocket(t *testing.T, srv *httptest.Server, room *Room) (*websocket.Conn, models.Session) {
+	t.Helper()
+	session, err := CreateSession(false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/" + room.ID + "?session=" + session.ID
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, session
+}
+
+// readUntil reads frames from conn until one contains want.
+func readUntil(t *testing.T, conn *websocket.Conn, want string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("reading a frame with %q: %v", want, err)
+		}
+		if strings.Contains(string(msg), want) {
+			return
+		}
+	}
+}
+
+// readCloseFrame reads frames from conn until the server closes it, and
+// returns the close frame it sent.
+func readCloseFrame(t *testing.T, conn *websocket.Conn) *websocket.CloseError {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	for {
+		_, _, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return closeErr
+		}
+		if err != nil {
+			t.Fatalf("reading until the close frame: %v", err)
+		}
+	}
+}
+
+// onHub runs send, which hands something to a hub, and fails the test if
+// the hub is stuck and does not take it.
+func onHub(t *testing.T, send func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		send()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("hub is stuck")
+	}
+}
+
+func broadcast(t *testing.T, h *Hub, msg string) {
+	t.Helper()
+	onHub(t, func() { h.Broadcast(msg) })
+}
+
+// hubClient returns the client of userID registered with h, or nil. It asks
+// the hub goroutine, which owns the clients.
+func hubClient(t *testing.T, h *Hub, userID string) *Client {
+	t.Helper()
+	var found *Client
+	onHub(t, func() {
+		h.broadcast <- delivery{to: func(c *Client) bool {
+			if c.session.UserID == userID {
+				found = c
+			}
+			return false
+		}}
+		// The hub handles one delivery at a time, so once it has taken the
+		// next one, found is set.
+		h.broadcast <- delivery{to: func(*Client) bool { return false }}
+	})
+	return found
+}
+
+// waitFor polls cond until it holds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHubEvictsSlowClient(t *testing.T) {
+	room, srv := startTestRoom(t, "ws-slow")
+	slow, session := dialTestClient(t, srv, room)
+
+	// slow reads nothing more, so its writer blocks once the socket buffers
+	// are full and the hub evicts it when its send queue is full, too.
+	for i := 0; hubClient(t, room.Hub, session.UserID) != nil; i++ {
+		if i == 1000 {
+			t.Fatal("slow client was never evicted")
+		}
+		broadcast(t, room.Hub, bigFrame)
+	}
+
+	// The writer still flushes the queue, then says why it closes.
+	closeErr := readCloseFrame(t, slow)
+	if closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "client too slow" {
+		t.Errorf("close frame = %d %q, want %d %q",
+			closeErr.Code, closeErr.Text, websocket.ClosePolicyViolation, "client too slow")
+	}
+}
+
+func TestHubDeliversPastBlockedClient(t *testing.T) {
+	room, srv := startTestRoom(t, "ws-blocked")
+	_, blockedSession := dialTestClient(t, srv, room)
+	fast, _ := dialTestClient(t, srv, room)
+
+	fast.SetReadDeadline(time.Time{})
+	frames := make(chan string, sendQueueSize)
+	go func() {
+		for {
+			_, msg, err := fast.ReadMessage()
+			if err != nil {
+				close(frames)
+				return
+			}
+			frames <- string(msg)
+		}
+	}()
+	receive := func(want string) {
+		t.Helper()
+		timeout := time.After(testTimeout)
+		for {
+			select {
+			case msg, ok := <-frames:
+				if !ok {
+					t.Fatalf("fast client closed before receiving %.20q", want)
+				}
+				if msg == want {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("fast client did not receive %.20q", want)
+			}
+		}
+	}
+
+	// Fill the blocked client's socket and half its send queue, at the pace
+	// the fast client reads.
+	blocked := hubClient(t, room.Hub, blockedSession.UserID)
+	for len(blocked.send) < sendQueueSize/2 {
+		broadcast(t, room.Hub, bigFrame)
+		receive(bigFrame)
+	}
+
+	broadcast(t, room.Hub, "after")
+	receive("after")
+	if hubClient(t, room.Hub, blockedSession.UserID) == nil {
+		t.Error("blocked client was evicted before its send queue was full")
+	}
+}
+
+func TestHubUnregistersClientWhoseWriteFails(t *testing.T) {
+	room, srv := startTestRoom(t, "ws-write-error")
+	_, session := dialTestClient(t, srv, room)
+	client := hubClient(t, room.Hub, session.UserID)
+
+	// With the sending half shut down, the next write fails but reads still
+	// work until the writer closes the connection.
+	if err := client.conn.UnderlyingConn().(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	broadcast(t, room.Hub, "hello")
+
+	writerDone := make(chan struct{})
+	go func() {
+		room.Hub.writers.Wait()
+		close(writerDone)
+	}()
+	select {
+	case <-writerDone:
+	case <-time.After(testTimeout):
+		t.Fatal("writer did not exit after a failed write")
+	}
+	waitFor(t, "the client to be unregistered", func() bool {
+		return hubClient(t, room.Hub, session.UserID) == nil
+	})
+
+	// The room carries on for the others.
+	other, _ := dialTestClient(t, srv, room)
+	broadcast(t, room.Hub, "still here")
+	readUntil(t, other, "still here")
+}
+
+func TestHubShutdownSendsNoticeAndCloseFrame(t *testing.T) {
+	room, srv := startTestRoom(t, "ws-shutdown")
+	conn, _ := dialTestClient(t, srv, room)
+
+	done := make(chan struct{})
+	go func() {
+		room.Hub.Shutdown()
+		close(done)
+	}()
+
+	readUntil(t, conn, "The server is restarting")
+	closeErr := readCloseFrame(t, conn)
+	if closeErr.Code != websocket.CloseServiceRestart || closeErr.Text != "server restarting" {
+		t.Errorf("close frame = %d %q, want %d %q",
+			closeErr.Code, closeErr.Text, websocket.CloseServiceRestart, "server restarting")
+	}
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("Shutdown did not return after the close frame went out")
+	}
+
+	// Clients connecting after the shutdown are turned away the same way.
+	late, _ := dialTestSocket(t, srv, room)
+	if closeErr := readCloseFrame(t, late); closeErr.Code != websocket.CloseServiceRestart {
+		t.Errorf("late client close code = %d, want %d", closeErr.Code, websocket.CloseServiceRestart)
+	}
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"temp0ral-chat/controllers"
+	"temp0ral-chat/helpers"
+	"temp0ral-chat/models"
+	"temp0ral-chat/store"
+	"temp0ral-chat/templates"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/gin-gonic/gin"
+)
+
+// exportPageSize is how many messages an export reads from the store at a
+// time, so a long history never has to fit in memory at once.
+const exportPageSize = 100
+
+// exportFormats maps the format parameter of /export to the file extension
+// and content type of the transcript.
+var exportFormats = map[string]struct {
+	ext         string
+	contentType string
+	write       func(w io.Writer, roomID string, viewer models.Viewer, exportedAt time.Time) error
+}{
+	"json":     {"json", "application/json", writeJSONExport},
+	"html":     {"html", "text/html; charset=utf-8", writeHTMLExport},
+	"markdown": {"md", "text/markdown; charset=utf-8", writeMarkdownExport},
+}
+
+// Export streams a transcript of a room, the one in the room parameter or
+// else the one /chat leads to, as JSON, self-contained HTML or Markdown. It
+// holds the messages the chat page can show the caller.
+func Export(c *gin.Context) {
+	userSession := c.MustGet("session").(models.Session)
+
+	format, ok := exportFormats[c.DefaultQuery("format", "json")]
+	if !ok {
+		c.String(http.StatusBadRequest, "Format must be json, html or markdown")
+		return
+	}
+
+	roomID := c.Query("room")
+	if roomID == "" {
+		roomID = models.App.Rooms.Default
+		if userSession.RoomID != "" {
+			roomID = userSession.RoomID
+		}
+	}
+	room, err := controllers.EnterRoom(userSession, roomID)
+	if errors.Is(err, store.ErrRoomNotFound) {
+		c.String(http.StatusNotFound, "Room not found")
+		return
+	}
+	if err != nil {
+		log.Println("Fetch room error:", err)
+		c.String(http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	helpers.UpdateUserInteraction(userSession.UserID)
+
+	exportedAt := time.Now()
+	c.Header("Content-Type", format.contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="temp0ral-%s-%s.%s"`,
+		room.ID, exportedAt.Format("20060102-150405"), format.ext))
+	c.Status(http.StatusOK)
+
+	// The status is already sent, so a failure can only cut the file short.
+	if err := format.write(c.Writer, room.ID, helpers.Viewer(userSession), exportedAt); err != nil {
+		log.Printf("Error exporting room %s: %v", room.ID, err)
+	}
+}
+
+// eachExported calls fn with every message in a room that viewer may see,
+// oldest first, reading a page at a time and flushing w after each.
+func eachExported(w io.Writer, roomID string, viewer models.Viewer, fn func(models.Message) error) error {
+	afterID := 0
+	for {
+		page, err := store.Messages.After(roomID, afterID, exportPageSize)
+		if err != nil {
+			return err
+		}
+		for _, msg := range page {
+			if err := fn(msg); err != nil {
+				return err
+			}
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		if len(page) < exportPageSize {
+			return nil
+		}
+		afterID = page[len(page)-1].ID
+	}
+}
+
+type exportedMessage struct {
+	ID        int                `json:"id"`
+	Kind      models.MessageKind `json:"kind,omitempty"`
+	Username  string             `json:"username"`
+	UserID    string             `json:"user_id"`
+	Content   string             `json:"content"`
+	Image     string             `json:"image,omitempty"`
+	CreatedAt time.Time          `json:"created_at"`
+	EditedAt  *time.Time         `json:"edited_at,omitempty"`
+	Pinned    bool               `json:"pinned,omitempty"`
+	ReplyTo   []int              `json:"reply_to,omitempty"`
+	Reactions []exportedReaction `json:"reactions,omitempty"`
+}
+
+type exportedReaction struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// writeJSONExport writes {"room", "exported_at", "messages": [...]}, one
+// message at a time.
+func writeJSONExport(w io.Writer, roomID string, viewer models.Viewer, exportedAt time.Time) error {
+	header, err := json.Marshal(struct {
+		Room       string    `json:"room"`
+		ExportedAt time.Time `json:"exported_at"`
+	}{roomID, exportedAt})
+	if err != nil {
+		return err
+	}
+	// Reopen the header object to append the messages array to it.
+	if _, err := fmt.Fprintf(w, `%s,"messages":[`, header[:len(header)-1]); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	first := true
+	err = eachExported(w, roomID, viewer, func(msg models.Message) error {
+		out := exportedMessage{
+			ID:        msg.ID,
+			Kind:      msg.Kind,
+			Username:  msg.Username,
+			UserID:    msg.UserID,
+			Content:   msg.Content,
+			Image:     msg.ImagePath,
+			CreatedAt: msg.CreatedAt,
+			Pinned:    msg.Pinned(),
+			ReplyTo:   msg.ReplyTo,
+		}
+		if !msg.EditedAt.IsZero() {
+			out.EditedAt = &msg.EditedAt
+		}
+		for _, r := range msg.Reactions {
+			out.Reactions = append(out.Reactions, exportedReaction{r.Emoji, len(r.UserIDs)})
+		}
+
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		return enc.Encode(out)
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// writeHTMLExport renders templates.ExportPage with the messages streamed
+// in as its children and their images inlined.
+func writeHTMLExport(w io.Writer, roomID string, viewer models.Viewer, exportedAt time.Time) error {
+	messages := templ.ComponentFunc(func(ctx context.Context, cw io.Writer) error {
+		return eachExported(w, roomID, viewer, func(msg models.Message) error {
+			return templates.ExportMessage(msg, imageDataURI(msg.ImagePath)).Render(ctx, cw)
+		})
+	})
+	ctx := templ.WithChildren(context.Background(), messages)
+	return templates.ExportPage(roomID, exportedAt).Render(ctx, w)
+}
+
+// imageDataURI returns an uploaded image as a data URI, or "" if there is
+// none or it cannot be read.
+func imageDataURI(imagePath string) string {
+	if imagePath == "" {
+		return ""
+	}
+	data, err := os.ReadFile("." + imagePath)
+	if err != nil {
+		log.Printf("Error reading image %s for export: %v", imagePath, err)
+		return ""
+	}
+	return "data:" + http.DetectContentType(data) + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// markdownEscaper keeps usernames from turning into Markdown formatting.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "<", `\<`,
+)
+
+// htmlEscaper keeps message content from turning into live HTML, which most
+// Markdown viewers would render, while leaving its formatting alone.
+var htmlEscaper = strings.NewReplacer("<", `\<`)
+
+// writeMarkdownExport writes a heading per room and a paragraph per
+// message. Content is kept as typed, since chat formatting is close to
+// Markdown already, except that HTML tags are escaped.
+func writeMarkdownExport(w io.Writer, roomID string, viewer models.Viewer, exportedAt time.Time) error {
+	if _, err := fmt.Fprintf(w, "# #%s\n\nExported from temp0ral-chat at %s\n",
+		roomID, exportedAt.Format("2006-01-02 15:04:05 MST")); err != nil {
+		return err
+	}
+
+	return eachExported(w, roomID, viewer, func(msg models.Message) error {
+		var b strings.Builder
+		name := "**" + markdownEscaper.Replace(msg.Username) + "**"
+		if msg.Kind == models.KindAction {
+			name = `\* ` + name
+		}
+		fmt.Fprintf(&b, "\n%s `%s` %s (>>%d)", name,
+			msg.UserID[:8], msg.CreatedAt.Format("2006-01-02 15:04:05"), msg.ID)
+		if !msg.EditedAt.IsZero() {
+			b.WriteString(" (edited)")
+		}
+		b.WriteString("  \n")
+		if msg.Content != "" {
+			b.WriteString(strings.ReplaceAll(htmlEscaper.Replace(msg.Content), "\n", "  \n"))
+			b.WriteString("\n")
+		}
+		if msg.ImagePath != "" {
+			fmt.Fprintf(&b, "![User uploaded image](%s)\n", msg.ImagePath)
+		}
+		if len(msg.Reactions) > 0 {
+			reactions := make([]string, len(msg.Reactions))
+			for i, r := range msg.Reactions {
+				reactions[i] = fmt.Sprintf("%s %d", r.Emoji, len(r.UserIDs))
+			}
+			b.WriteString(strings.Join(reactions, " · ") + "\n")
+		}
+		_, err := io.WriteString(w, b.String())
+		return err
+	})
+}
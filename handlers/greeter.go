@@ -9,7 +9,8 @@ import (
 
 func Greeter(c *gin.Context) {
 	errorMsg := c.Query("error")
-	component := templates.Greeter(errorMsg)
+	// A private room's invite link carries its name and access key.
+	component := templates.Greeter(errorMsg, c.Query("room"), c.Query("key"))
 	handler := templ.Handler(component)
 	handler.ServeHTTP(c.Writer, c.Request)
 }
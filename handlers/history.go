@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"temp0ral-chat/controllers"
+	"temp0ral-chat/helpers"
+	"temp0ral-chat/models"
+	"temp0ral-chat/store"
+	"temp0ral-chat/templates"
+
+	"github.com/gin-gonic/gin"
+)
+
+// History renders the page of a room's messages before the before_id
+// parameter, for scrolling back past what Room loaded.
+func History(c *gin.Context) {
+	userSession := c.MustGet("session").(models.Session)
+
+	beforeID, err := strconv.Atoi(c.Query("before_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid before_id")
+		return
+	}
+
+	room, err := controllers.EnterRoom(userSession, c.Param("room"))
+	if errors.Is(err, store.ErrRoomNotFound) {
+		c.String(http.StatusNotFound, "Room not found")
+		return
+	}
+	if err != nil {
+		log.Println("Fetch room error:", err)
+		c.String(http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	messages, err := store.Messages.Before(room.ID, beforeID, models.App.Messages.PageSize+1)
+	if err != nil {
+		log.Println("Fetch messages error:", err)
+		c.String(http.StatusInternalServerError, "Database error")
+		return
+	}
+	messages, hasOlder := page(messages)
+
+	c.Header("Content-Type", "text/html")
+	templates.OlderMessages(room.ID, messages, hasOlder, helpers.Viewer(userSession)).Render(c.Request.Context(), c.Writer)
+}
+
+// page cuts messages, fetched as one more than the page size, down to a
+// page, reporting whether there are older ones.
+func page(messages []models.Message) ([]models.Message, bool) {
+	if len(messages) > models.App.Messages.PageSize {
+		return messages[1:], true
+	}
+	return messages, false
+}
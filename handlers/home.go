@@ -1,76 +1,79 @@
 package handlers
 
 import (
-	"database/sql"
+	"errors"
+	"log"
 	"net/http"
-	"strings"
+	"temp0ral-chat/controllers"
 	"temp0ral-chat/helpers"
 	"temp0ral-chat/models"
+	"temp0ral-chat/store"
 	"temp0ral-chat/templates"
-	"temp0ral-chat/utils"
 
 	"github.com/a-h/templ"
 	"github.com/gin-gonic/gin"
 )
 
+// Home sends /chat to the default room, or guests to their private room.
 func Home(c *gin.Context) {
+	userSession := c.MustGet("session").(models.Session)
+	if userSession.RoomID != "" {
+		c.Redirect(http.StatusFound, "/r/"+userSession.RoomID)
+		return
+	}
+	c.Redirect(http.StatusFound, "/r/"+models.App.Rooms.Default)
+}
+
+// Room renders the chat page of the room in :room.
+func Room(c *gin.Context) {
 	session, _ := c.Get("session")
 	userSession := session.(models.Session)
 
-	helpers.UpdateUserActivity(userSession.UserID)
-
-	activeSessions := helpers.GetActiveSessions()
-	activeUserIDs := make([]string, len(activeSessions))
-	for i, sess := range activeSessions {
-		activeUserIDs[i] = sess.UserID
+	room, err := controllers.EnterRoom(userSession, c.Param("room"))
+	if errors.Is(err, store.ErrRoomNotFound) {
+		c.String(http.StatusNotFound, "Room not found")
+		return
 	}
-
-	if len(activeUserIDs) == 0 {
-		component := templates.Chat([]models.Message{}, userSession.UserID, activeSessions)
-		handler := templ.Handler(component)
-		handler.ServeHTTP(c.Writer, c.Request)
+	if err != nil {
+		log.Println("Fetch room error:", err)
+		c.String(http.StatusInternalServerError, "Database error")
 		return
 	}
 
-	placeholders := make([]string, len(activeUserIDs))
-	args := make([]interface{}, len(activeUserIDs))
-	for i, userID := range activeUserIDs {
-		placeholders[i] = "$" + string(rune('0'+i+1))
-		args[i] = userID
-	}
+	helpers.UpdateUserInteraction(userSession.UserID)
+
+	activeSessions := room.ActiveSessions()
 
-	query := `
-		SELECT id, username, content, created_at, user_id, image_path
-		FROM (
-			SELECT * FROM messages 
-			WHERE user_id IN (` + strings.Join(placeholders, ",") + `)
-			ORDER BY created_at DESC LIMIT 500
-		) sub 
-		ORDER BY created_at ASC
-	`
+	// Read the sequence number before the history so anything published in
+	// between is replayed rather than missed.
+	seq := room.Hub.Seq()
 
-	rows, err := utils.DB.Query(query, args...)
+	messages, err := store.Messages.Recent(room.ID, models.App.Messages.PageSize+1)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Database error")
 		return
 	}
-	defer rows.Close()
+	messages, hasOlder := page(messages)
 
-	var messages []models.Message
-	for rows.Next() {
-		var m models.Message
-		var imagePath sql.NullString
-		if err := rows.Scan(&m.ID, &m.Username, &m.Content, &m.CreatedAt, &m.UserID, &imagePath); err != nil {
-			c.String(http.StatusInternalServerError, "Scan error")
-			return
-		}
-		if imagePath.Valid {
-			m.ImagePath = imagePath.String
+	pinned, err := store.Messages.Pinned(room.ID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	stored, err := store.Messages.Rooms()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Database error")
+		return
+	}
+	var rooms []models.Room
+	for _, r := range stored {
+		if r.Admits(userSession) {
+			rooms = append(rooms, r)
 		}
-		messages = append(messages, m)
 	}
 
-	component := templates.Chat(messages, userSession.UserID, activeSessions)
+	component := templates.Chat(room.Room, rooms, messages, hasOlder, pinned, helpers.Viewer(userSession), activeSessions, seq)
 	handler := templ.Handler(component)
 	handler.ServeHTTP(c.Writer, c.Request)
 }
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"temp0ral-chat/controllers"
+	"temp0ral-chat/helpers"
+	"temp0ral-chat/models"
+	"temp0ral-chat/store"
+	"temp0ral-chat/templates"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Message renders a single message as a fragment for >>id hover previews.
+// Messages hidden from the chat page, or already purged, get a placeholder.
+func Message(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	c.Header("Content-Type", "text/html")
+
+	userSession := c.MustGet("session").(models.Session)
+
+	msg, err := store.Messages.Visible(id)
+	if err == nil {
+		_, err = controllers.EnterRoom(userSession, msg.RoomID)
+	}
+	if errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrRoomNotFound) {
+		c.Status(http.StatusNotFound)
+		templates.ExpiredMessage(id).Render(c.Request.Context(), c.Writer)
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	templates.Message(msg, helpers.Viewer(userSession)).Render(c.Request.Context(), c.Writer)
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"temp0ral-chat/controllers"
+	"temp0ral-chat/helpers"
+	"temp0ral-chat/models"
+	"temp0ral-chat/store"
+	"temp0ral-chat/templates"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSearchResults is how many matches a search lists.
+const maxSearchResults = 50
+
+// Search renders the messages in a room matching the q parameter. Only the
+// history Room and History can show is searched, so every result can be
+// jumped to.
+func Search(c *gin.Context) {
+	userSession := c.MustGet("session").(models.Session)
+
+	room, err := controllers.EnterRoom(userSession, c.Param("room"))
+	if errors.Is(err, store.ErrRoomNotFound) {
+		c.String(http.StatusNotFound, "Room not found")
+		return
+	}
+	if err != nil {
+		log.Println("Fetch room error:", err)
+		c.String(http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	helpers.UpdateUserInteraction(userSession.UserID)
+
+	viewer := helpers.Viewer(userSession)
+	viewer.Highlight = store.SearchTerms(c.Query("q"))
+
+	results, err := store.Messages.Search(room.ID, viewer.Highlight,
+		models.App.Messages.HistoryLimit, maxSearchResults)
+	if err != nil {
+		log.Println("Search error:", err)
+		c.String(http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	c.Header("Content-Type", "text/html")
+	templates.SearchResults(results, viewer).Render(c.Request.Context(), c.Writer)
+}
@@ -1,6 +1,7 @@
 package helpers
 
 import (
+	"strings"
 	"temp0ral-chat/models"
 	"time"
 )
@@ -29,6 +30,20 @@ func GetActiveSessions() []models.Session {
 	return activeSessions
 }
 
+// GetActiveSession returns the live session of userID, if any.
+func GetActiveSession(userID string) (models.Session, bool) {
+	models.SessionsMutex.RLock()
+	defer models.SessionsMutex.RUnlock()
+
+	now := time.Now()
+	for _, session := range models.Sessions {
+		if session.UserID == userID && now.Before(session.ExpiresAt) {
+			return session, true
+		}
+	}
+	return models.Session{}, false
+}
+
 func GetActiveUserIDs() []string {
 	models.SessionsMutex.RLock()
 	defer models.SessionsMutex.RUnlock()
@@ -44,3 +59,22 @@ func GetActiveUserIDs() []string {
 
 	return activeUserIDs
 }
+
+// ResolveMentions maps short user IDs, as typed after @, to the live
+// sessions they belong to. Short IDs matching no one are dropped.
+func ResolveMentions(shortIDs []string) []string {
+	if len(shortIDs) == 0 {
+		return nil
+	}
+
+	var userIDs []string
+	for _, userID := range GetActiveUserIDs() {
+		for _, shortID := range shortIDs {
+			if strings.HasPrefix(userID, shortID) {
+				userIDs = append(userIDs, userID)
+				break
+			}
+		}
+	}
+	return userIDs
+}
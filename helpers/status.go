@@ -7,14 +7,14 @@ import (
 
 func GetUserStatus(userID string) string {
 	models.ActivityMutex.RLock()
-	lastActivity, exists := models.UserLastActivity[userID]
+	lastInteraction, exists := models.UserLastInteraction[userID]
 	models.ActivityMutex.RUnlock()
 
 	if !exists {
 		return "online"
 	}
 
-	if time.Since(lastActivity) > models.IdleThreshold {
+	if time.Since(lastInteraction) > models.App.Session.IdleThreshold {
 		return "idle"
 	}
 
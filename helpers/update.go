@@ -10,3 +10,13 @@ func UpdateUserActivity(userID string) {
 	models.UserLastActivity[userID] = time.Now()
 	models.ActivityMutex.Unlock()
 }
+
+// UpdateUserInteraction records that the user did something themselves,
+// which also counts as activity.
+func UpdateUserInteraction(userID string) {
+	now := time.Now()
+	models.ActivityMutex.Lock()
+	models.UserLastActivity[userID] = now
+	models.UserLastInteraction[userID] = now
+	models.ActivityMutex.Unlock()
+}
@@ -0,0 +1,13 @@
+package helpers
+
+import "temp0ral-chat/models"
+
+// Viewer describes session's user for rendering messages to them.
+func Viewer(session models.Session) models.Viewer {
+	return models.Viewer{
+		UserID:    session.UserID,
+		Nickname:  session.Nickname,
+		Moderator: session.Moderator,
+		Guest:     session.RoomID != "",
+	}
+}
@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"temp0ral-chat/config"
 	"temp0ral-chat/controllers"
 	"temp0ral-chat/models"
 	"temp0ral-chat/routes"
+	"temp0ral-chat/store"
 	"temp0ral-chat/utils"
 
 	_ "image/gif"
@@ -17,23 +25,91 @@ import (
 )
 
 func main() {
-	if err := utils.SetupDatabase(); err != nil {
-		log.Fatalf("Failed to setup database: %v", err)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	models.App = cfg
+
+	if err := store.Setup(cfg); err != nil {
+		log.Fatalf("Failed to setup message store: %v", err)
 	}
 	defer func() {
-		if err := utils.CloseDatabase(); err != nil {
-			log.Printf("Error closing database: %v", err)
+		if err := store.Close(); err != nil {
+			log.Printf("Error closing message store: %v", err)
 		}
 	}()
+	log.Printf("Using %s message store", cfg.Store.Driver)
 
-	controllers.StartPeriodicCleanup()
-	log.Printf("Started periodic session and message cleanup with idle threshold: %v", models.IdleThreshold)
+	if err := utils.CreateUploadsDirectory(); err != nil {
+		log.Fatalf("Failed to setup uploads: %v", err)
+	}
 
-	go controllers.GlobalHub.RunSocket()
+	stopCleanup := controllers.StartPeriodicCleanup()
+	log.Printf("Started periodic session and message cleanup with idle threshold: %v", cfg.Session.IdleThreshold)
 
 	r := gin.Default()
 	routes.Temp0ralRouter(r)
 
-	log.Printf("Server starting on :8080 with session duration: %v", models.SessionDuration)
-	r.Run(":8080")
+	srv := &http.Server{
+		Addr:    cfg.Server.Addr,
+		Handler: r,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Printf("Server starting on %s with session duration: %v", cfg.Server.Addr, cfg.Session.Duration)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server error: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Printf("Shutting down (timeout %v)", cfg.Shutdown.Timeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		shutdown(shutdownCtx, srv, stopCleanup, cfg.Shutdown.Purge)
+	}()
+
+	select {
+	case <-done:
+		log.Println("Shutdown complete")
+	case <-shutdownCtx.Done():
+		log.Println("Shutdown timed out, exiting anyway")
+	}
+}
+
+// shutdown drains the server in order: no new sessions, no more cleanup
+// ticks, every socket told and closed, in-flight requests and purges
+// finished, and finally (if enabled) every message and upload deleted.
+func shutdown(ctx context.Context, srv *http.Server, stopCleanup func(), purge bool) {
+	controllers.BeginShutdown()
+	stopCleanup()
+	controllers.ShutdownRooms()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down HTTP server: %v", err)
+	}
+	if err := controllers.WaitForPurges(ctx); err != nil {
+		log.Printf("Gave up waiting for message purges: %v", err)
+	}
+
+	if purge {
+		controllers.PurgeAll()
+	}
 }
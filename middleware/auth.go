@@ -1,9 +1,13 @@
 package middleware
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"net/url"
 	"temp0ral-chat/controllers"
 	"temp0ral-chat/models"
+	"temp0ral-chat/store"
 
 	"github.com/gin-gonic/gin"
 )
@@ -31,16 +35,52 @@ func AuthMiddleware() gin.HandlerFunc {
 }
 
 func SessionAuth(c *gin.Context) {
+	if controllers.ShuttingDown() {
+		c.Redirect(http.StatusFound, "/?error=server_restarting")
+		return
+	}
+
 	providedKey := c.PostForm("access_key")
 
-	if providedKey != models.AccessKey {
+	if roomID := c.PostForm("room"); roomID != "" {
+		guestAuth(c, roomID, providedKey)
+		return
+	}
+
+	auth := models.App.Auth
+	moderator := auth.ModeratorKey != "" && providedKey == auth.ModeratorKey
+	if providedKey != auth.AccessKey && !moderator {
 		c.Redirect(http.StatusFound, "/?error=invalid_key")
 		return
 	}
 
-	session := controllers.CreateSession()
+	session, err := controllers.CreateSession(moderator, "")
+	if err != nil {
+		log.Println("Create session error:", err)
+		c.Redirect(http.StatusFound, "/?error=server_error")
+		return
+	}
 
-	c.SetCookie("session_id", session.ID, int(models.SessionDuration.Seconds()), "/", "", false, true)
+	c.SetCookie("session_id", session.ID, int(models.App.Session.Duration.Seconds()), "/", "", false, true)
 
 	c.Redirect(http.StatusFound, "/chat")
 }
+
+// guestAuth signs a guest into the private room roomID with the room's own
+// access key. The session it creates cannot leave that room.
+func guestAuth(c *gin.Context, roomID, providedKey string) {
+	session, err := controllers.AdmitGuest(roomID, providedKey)
+	if errors.Is(err, store.ErrRoomNotFound) {
+		c.Redirect(http.StatusFound, "/?error=invalid_key&room="+url.QueryEscape(roomID))
+		return
+	}
+	if err != nil {
+		log.Println("Admit guest error:", err)
+		c.Redirect(http.StatusFound, "/?error=server_error")
+		return
+	}
+
+	c.SetCookie("session_id", session.ID, int(models.App.Session.Duration.Seconds()), "/", "", false, true)
+
+	c.Redirect(http.StatusFound, "/r/"+session.RoomID)
+}
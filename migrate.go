@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"temp0ral-chat/config"
+	"temp0ral-chat/utils"
+)
+
+const migrateUsage = "usage: temp0ral-chat migrate <status|up> [flags]"
+
+// runMigrate implements the `migrate status` and `migrate up` commands.
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	command := args[0]
+	if command != "status" && command != "up" {
+		return fmt.Errorf("unknown migrate command %q\n%s", command, migrateUsage)
+	}
+
+	cfg, err := config.Load(args[1:])
+	if err != nil {
+		return err
+	}
+	if cfg.Store.Driver != "postgres" {
+		return fmt.Errorf("migrations only apply to the postgres store, configured store is %q", cfg.Store.Driver)
+	}
+
+	if err := utils.ConnectDatabase(cfg.Database); err != nil {
+		return err
+	}
+	defer utils.CloseDatabase()
+
+	if command == "up" {
+		if err := utils.Migrate(utils.DB); err != nil {
+			return err
+		}
+	}
+	return printMigrationStatus()
+}
+
+func printMigrationStatus() error {
+	current, err := utils.SchemaVersion(utils.DB)
+	if err != nil {
+		return err
+	}
+	states, err := utils.MigrationStatus(utils.DB)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Schema version: %d\n\n", current)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, s := range states {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	if latest := len(states); latest > 0 && current > states[latest-1].Version {
+		fmt.Fprintf(w, "\nWARNING: database is newer than this binary (knows up to %d)\n", states[latest-1].Version)
+	}
+	return w.Flush()
+}
@@ -5,5 +5,15 @@ import (
 	"time"
 )
 
+// UserLastActivity is refreshed by anything proving the user is still
+// connected, including WebSocket pongs and heartbeats. Sessions are only
+// terminated once it goes stale.
 var UserLastActivity = make(map[string]time.Time)
+
+// UserLastInteraction is refreshed only when the user is actually at the
+// tab: loading the page, posting, or sending heartbeats while focused. It
+// decides whether a user is shown as idle.
+var UserLastInteraction = make(map[string]time.Time)
+
+// ActivityMutex guards both UserLastActivity and UserLastInteraction.
 var ActivityMutex sync.RWMutex
@@ -1,17 +1,9 @@
 package models
 
-import "time"
+import "temp0ral-chat/config"
 
-const (
-	DBUser          = ""
-	DBPassword      = ""
-	DBName          = ""
-	DBHost          = ""
-	DBPort          = "5432"
-	AccessKey       = "test" // change in prod!
-	SessionDuration = 5 * time.Hour
-	CleanupInterval = 30 * time.Second
-	MaxUploadSize   = 5 * 1024 * 1024  // 5 MB upload limit!
-	IdleThreshold   = 3 * time.Second  // Users idle after N seconds of no activity
-	MaxIdleTime     = 60 * time.Second // Terminate sessions after N minutes of inactivity
-)
+// App is the runtime configuration. main replaces it with the result of
+// config.Load before any session, handler or cleanup loop is started, and
+// everything that runs from then on reads it here. Only the setup of the
+// store and database, which `migrate` also runs, is handed its config.
+var App = config.Default()
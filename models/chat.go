@@ -1,19 +1,100 @@
 package models
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 type Message struct {
 	ID        int
+	RoomID    string
+	Kind      MessageKind
 	Username  string
 	Content   string
 	UserID    string
 	ImagePath string
 	CreatedAt time.Time
+	EditedAt  time.Time // Zero unless the message has been edited
+	PinnedAt  time.Time // Zero unless the message is pinned
+
+	// ReplyTo lists the messages this one references with >>id, Replies
+	// the messages that reference this one. Both are ascending by ID.
+	ReplyTo []int
+	Replies []int
+
+	// Mentions holds the user IDs of the live sessions @mentioned when the
+	// message was posted.
+	Mentions []string
+
+	// Reactions are in the order each was first used.
+	Reactions []Reaction
+}
+
+// MessageKind says how a message was posted, which decides how it looks.
+type MessageKind string
+
+const (
+	// KindText is a message as the user typed it.
+	KindText MessageKind = ""
+	// KindAction is a /me message, shown as something the author did.
+	KindAction MessageKind = "action"
+	// KindSystem is written by the server, e.g. the result of /roll, and
+	// styled so it cannot be mistaken for typed text. It cannot be edited.
+	KindSystem MessageKind = "system"
+)
+
+// Pinned reports whether the message is pinned to its room.
+func (m Message) Pinned() bool {
+	return !m.PinnedAt.IsZero()
+}
+
+// Reaction is one emoji or kaomoji on a message and who reacted with it.
+type Reaction struct {
+	Emoji   string
+	UserIDs []string // In the order they reacted
+}
+
+// Revision is an earlier version of a message's content, kept when the
+// author edits it.
+type Revision struct {
+	MessageID  int
+	Content    string
+	ReplacedAt time.Time
+}
+
+// Viewer is the user a message is rendered for, which decides the controls
+// and styling they get.
+type Viewer struct {
+	UserID    string
+	Nickname  string // Set with /nick
+	Moderator bool
+	Guest     bool     // Confined to a private room
+	Highlight []string // Search terms to mark in message content
+}
+
+// Reacted reports whether the viewer is one of those who reacted with r.
+func (v Viewer) Reacted(r Reaction) bool {
+	return slices.Contains(r.UserIDs, v.UserID)
+}
+
+// Owns reports whether the viewer wrote msg.
+func (v Viewer) Owns(msg Message) bool {
+	return msg.UserID == v.UserID
+}
+
+// Mentioned reports whether msg @mentions the viewer.
+func (v Viewer) Mentioned(msg Message) bool {
+	return slices.Contains(msg.Mentions, v.UserID)
 }
 
 type Session struct {
 	ID        string
 	UserID    string
+	Moderator bool
+	// RoomID is set for guests of a private room, who may not leave it.
+	RoomID string
+	// Nickname is set with /nick and used when a message has no username.
+	Nickname  string
 	ExpiresAt time.Time
 	CreatedAt time.Time
 }
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// DirectMessage is a private message between two sessions. Only the sender
+// and the recipient ever see it, and it goes when either session ends.
+type DirectMessage struct {
+	ID          int
+	SenderID    string
+	RecipientID string
+	Content     string
+	CreatedAt   time.Time
+}
+
+// Peer returns the other party of the conversation from userID's side.
+func (dm DirectMessage) Peer(userID string) string {
+	if dm.SenderID == userID {
+		return dm.RecipientID
+	}
+	return dm.SenderID
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Room is a separate chat, addressed as /r/{ID}. Each has its own hub,
+// user list and message history.
+type Room struct {
+	ID        string
+	CreatedAt time.Time
+
+	// AccessKey is set for private rooms, whose guests sign in with it
+	// instead of the instance-wide key. Empty for public rooms.
+	AccessKey string
+	// CreatedBy is the user ID of the session that created a private room.
+	CreatedBy string
+}
+
+func (r Room) Private() bool {
+	return r.AccessKey != ""
+}
+
+// Admits reports whether session may enter the room. Guests are confined to
+// the private room they signed into, and a private room admits only its
+// guests and its creator.
+func (r Room) Admits(session Session) bool {
+	if session.RoomID != "" {
+		return session.RoomID == r.ID
+	}
+	return !r.Private() || r.CreatedBy == session.UserID
+}
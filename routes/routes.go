@@ -4,6 +4,7 @@ import (
 	"temp0ral-chat/controllers"
 	"temp0ral-chat/handlers"
 	"temp0ral-chat/middleware"
+	"temp0ral-chat/models"
 	"temp0ral-chat/templates"
 
 	"github.com/gin-gonic/gin"
@@ -18,8 +19,25 @@ func Temp0ralRouter(r *gin.Engine) {
 	r.GET("/", handlers.Greeter)
 	r.POST("/auth", middleware.SessionAuth)
 	r.GET("/chat", middleware.AuthMiddleware(), handlers.Home)
-	r.GET("/ws", middleware.AuthMiddleware(), controllers.WebSocketHandler)
-	r.POST("/send-message", middleware.AuthMiddleware(), controllers.SendMessage)
+	r.GET("/r/:room", middleware.AuthMiddleware(), handlers.Room)
+	r.GET("/r/:room/ws", middleware.AuthMiddleware(), controllers.WebSocketHandler)
+	r.GET("/r/:room/messages", middleware.AuthMiddleware(), handlers.History)
+	r.GET("/r/:room/search", middleware.AuthMiddleware(), handlers.Search)
+	r.POST("/r/:room/send-message", middleware.AuthMiddleware(), controllers.SendMessage)
+	if models.App.Export.Enabled {
+		r.GET("/export", middleware.AuthMiddleware(), handlers.Export)
+	}
+	r.POST("/rooms", middleware.AuthMiddleware(), controllers.CreateRoomHandler)
+	r.PUT("/edit-message/:id", middleware.AuthMiddleware(), controllers.EditMessage)
+	r.GET("/message/:id", middleware.AuthMiddleware(), handlers.Message)
+	r.GET("/message/:id/revisions", middleware.AuthMiddleware(), controllers.MessageRevisions)
+	r.GET("/message/:id/reactions", middleware.AuthMiddleware(), controllers.ReactionPicker)
+	r.POST("/message/:id/reactions", middleware.AuthMiddleware(), controllers.ToggleReaction)
+	r.POST("/message/:id/pin", middleware.AuthMiddleware(), controllers.PinMessage)
+	r.DELETE("/message/:id/pin", middleware.AuthMiddleware(), controllers.UnpinMessage)
+	r.DELETE("/delete-message/:id", middleware.AuthMiddleware(), controllers.DeleteMessage)
+	r.GET("/dm/:userID", middleware.AuthMiddleware(), controllers.DirectConversation)
+	r.POST("/dm/:userID", middleware.AuthMiddleware(), controllers.SendDirectMessage)
 	r.POST("/logout", middleware.AuthMiddleware(), controllers.Logout)
 	r.GET("/emojis", middleware.AuthMiddleware(), templates.Emojis)
 	r.POST("/add-emoji", middleware.AuthMiddleware(), templates.AddEmoji)
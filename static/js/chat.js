@@ -26,13 +26,388 @@ document.addEventListener('click', function (e) {
     }
 });
 
+// New messages only scroll the chat down if it was already at the bottom, so
+// reading older pages is not interrupted.
+const FOLLOW_THRESHOLD_PX = 50;
+let followMessages = true;
+
+document.addEventListener('htmx:wsBeforeMessage', function () {
+    const messages = document.getElementById('messages');
+    followMessages = messages.scrollHeight - messages.scrollTop - messages.clientHeight < FOLLOW_THRESHOLD_PX;
+});
+
 document.addEventListener('htmx:wsAfterMessage', function () {
+    const seq = document.getElementById('ws-seq');
+    if (seq && seq.dataset.reload) {
+        window.location.reload();
+        return;
+    }
+
+    removeDuplicateMessages();
+
+    if (followMessages) {
+        scrollToBottom();
+    }
+
+    handleMention();
+    handleDirectMessage();
+});
+
+// The server swaps #ws-mention in, only for us, right after a message that
+// @mentions us.
+function handleMention() {
+    const notice = document.getElementById('ws-mention');
+    if (!notice || !notice.dataset.messageId) return;
+
+    const { messageId, username, content } = notice.dataset;
+    delete notice.dataset.messageId;
+
+    const message = document.getElementById('msg-' + messageId);
+    if (message) {
+        message.classList.add('mentioned');
+    }
+
+    if ('Notification' in window && Notification.permission === 'granted' && !document.hasFocus()) {
+        const notification = new Notification(username + ' mentioned you', { body: content, tag: 'mention-' + messageId });
+        notification.onclick = function () {
+            window.focus();
+            if (message) highlightMessage(message);
+        };
+    }
+}
+
+// Direct messages arrive in #ws-dm, only for their sender and recipient.
+// They go into the DM pane when that conversation is open; incoming ones for
+// other conversations are counted as unread on the sender's user-list entry.
+const unreadDirectMessages = {};
+
+function handleDirectMessage() {
+    const notice = document.getElementById('ws-dm');
+    if (!notice || !notice.dataset.peer) return;
+
+    const { peer, incoming } = notice.dataset;
+    const message = notice.firstElementChild;
+    delete notice.dataset.peer;
+
+    const pane = document.getElementById('dm-pane');
+    const conversation = document.getElementById('dm-messages');
+    if (!pane.hidden && conversation && conversation.dataset.peer === peer) {
+        conversation.appendChild(message);
+        conversation.scrollTop = conversation.scrollHeight;
+        return;
+    }
+
+    if (incoming === 'true') {
+        unreadDirectMessages[peer] = (unreadDirectMessages[peer] || 0) + 1;
+        showUnreadDirectMessages();
+    }
+}
+
+function showUnreadDirectMessages() {
+    document.querySelectorAll('#user-list .user-item').forEach(item => {
+        const count = unreadDirectMessages[item.dataset.userId] || 0;
+        let badge = item.querySelector('.dm-unread');
+        if (!count) {
+            if (badge) badge.remove();
+            return;
+        }
+        if (!badge) {
+            badge = document.createElement('span');
+            badge.className = 'dm-unread';
+            item.appendChild(badge);
+        }
+        badge.textContent = count;
+    });
+}
+
+function closeDirectMessages() {
+    const pane = document.getElementById('dm-pane');
+    pane.hidden = true;
+    pane.innerHTML = '';
+}
+
+document.body.addEventListener('htmx:afterSwap', function (evt) {
+    if (evt.target.id !== 'dm-pane') return;
+
+    const conversation = document.getElementById('dm-messages');
+    evt.target.hidden = false;
+    conversation.scrollTop = conversation.scrollHeight;
+    delete unreadDirectMessages[conversation.dataset.peer];
+    showUnreadDirectMessages();
+    evt.target.querySelector('input[name="chat_message"]').focus();
+});
+
+// The user list is re-rendered from scratch, so put the badges back.
+document.body.addEventListener('htmx:oobAfterSwap', function (evt) {
+    if (evt.target.id === 'user-list') {
+        showUnreadDirectMessages();
+    }
+});
+
+// Browsers only let a page ask for notification permission from a user
+// gesture, so ask on the first one.
+document.addEventListener('click', function requestNotifications() {
+    document.removeEventListener('click', requestNotifications);
+    if ('Notification' in window && Notification.permission === 'default') {
+        Notification.requestPermission();
+    }
+});
+
+// >>id links and backlinks scroll to the referenced message and flash it.
+// Touch has no hover, so there the first tap shows the preview instead and
+// the second one follows the link.
+document.addEventListener('click', function (e) {
+    const link = e.target.closest('.post-reference');
+    if (!link) {
+        hidePostPreview();
+        return;
+    }
+    if (lastPointerType === 'touch' && link !== postPreview.link) {
+        e.preventDefault();
+        showPostPreview(link);
+        return;
+    }
+
+    const target = document.getElementById('msg-' + link.dataset.ref);
+    if (!target) return;
+
+    e.preventDefault();
+    hidePostPreview();
+    highlightMessage(target);
+    history.replaceState(null, '', '#' + target.id);
+});
+
+// Search results are copies of messages on the page: clicking one jumps to
+// the real message.
+document.addEventListener('click', function (e) {
+    const result = e.target.closest('.search-result');
+    if (!result || e.target.closest('a, button')) return;
+
+    jumpToMessage(result.dataset.ref);
+});
+
+// History is loaded a page at a time: the .load-older button at the top of
+// #messages is replaced by the page before it, either when clicked or when
+// the user scrolls near the top.
+const LOAD_OLDER_THRESHOLD_PX = 100;
+let pendingJump = null;
+
+// loadOlderMessages requests the previous page, reporting whether there is
+// one to wait for.
+function loadOlderMessages() {
+    const older = document.querySelector('#messages .load-older');
+    if (!older) return false;
+    if (!older.classList.contains('htmx-request')) {
+        htmx.trigger(older, 'loadOlder');
+    }
+    return true;
+}
+
+// jumpToMessage scrolls to message id and flashes it, loading older pages
+// until it turns up.
+function jumpToMessage(id) {
+    const target = document.getElementById('msg-' + id);
+    if (target) {
+        pendingJump = null;
+        highlightMessage(target);
+        history.replaceState(null, '', '#' + target.id);
+        return;
+    }
+    pendingJump = loadOlderMessages() ? id : null;
+}
+
+document.addEventListener('DOMContentLoaded', function () {
     const messages = document.getElementById('messages');
-    messages.scrollTop = messages.scrollHeight;
+    scrollToBottom();
+    messages.addEventListener('scroll', function () {
+        if (messages.scrollTop < LOAD_OLDER_THRESHOLD_PX) {
+            loadOlderMessages();
+        }
+    });
+});
 
-    highlightRepliedMessages();
+// Keep the messages on screen in place while a page is inserted above them.
+// A rejected message or slash command comes back as an error status with
+// the reason for #error-container, which htmx would otherwise drop.
+document.body.addEventListener('htmx:beforeSwap', function (evt) {
+    if (!evt.detail.elt.classList.contains('chat-form')) return;
+    if (evt.detail.xhr.status >= 400 && evt.detail.serverResponse.includes('error-message')) {
+        evt.detail.shouldSwap = true;
+        evt.detail.isError = false;
+    }
 });
 
+document.body.addEventListener('htmx:beforeSwap', function (evt) {
+    if (!evt.detail.elt.classList.contains('load-older')) return;
+    if (!evt.detail.shouldSwap) {
+        pendingJump = null;
+        return;
+    }
+
+    const messages = document.getElementById('messages');
+    const fromBottom = messages.scrollHeight - messages.scrollTop;
+    requestAnimationFrame(function () {
+        messages.scrollTop = messages.scrollHeight - fromBottom;
+        if (pendingJump !== null) {
+            jumpToMessage(pendingJump);
+        }
+    });
+});
+
+document.body.addEventListener('htmx:afterSwap', function (e) {
+    if (e.detail.target.id !== 'search-results') return;
+    // The copies must not steal ids from the real messages.
+    e.detail.target.querySelectorAll('.search-result [id]').forEach(el => el.removeAttribute('id'));
+});
+
+// Hovering (or tapping) a >>id link previews the message it points to, which
+// may be far up the scrollback or already gone.
+const postPreview = { link: null };
+let lastPointerType = 'mouse';
+
+document.addEventListener('pointerdown', function (e) {
+    lastPointerType = e.pointerType;
+}, true);
+
+document.addEventListener('mouseover', function (e) {
+    // A tap fires mouseover too, before its click decides what to do.
+    if (lastPointerType === 'touch') return;
+    const link = e.target.closest('.post-reference');
+    if (!link || link === postPreview.link) return;
+    showPostPreview(link);
+});
+
+document.addEventListener('mouseout', function (e) {
+    const link = e.target.closest('.post-reference');
+    if (link && !link.contains(e.relatedTarget)) {
+        hidePostPreview();
+    }
+});
+
+function showPostPreview(link) {
+    postPreview.link = link;
+    htmx.ajax('GET', '/message/' + encodeURIComponent(link.dataset.ref), {
+        source: link,
+        target: '#post-preview',
+        swap: 'innerHTML',
+    });
+}
+
+// A preview arriving after its link was left is dropped. Expired messages
+// come back as a 404 with a placeholder to show.
+document.body.addEventListener('htmx:beforeSwap', function (e) {
+    if (e.detail.target.id !== 'post-preview') return;
+    if (e.detail.elt !== postPreview.link) {
+        e.detail.shouldSwap = false;
+    } else if (e.detail.xhr.status === 404) {
+        e.detail.shouldSwap = true;
+        e.detail.isError = false;
+    }
+});
+
+document.body.addEventListener('htmx:afterSwap', function (e) {
+    const preview = e.detail.target;
+    if (preview.id !== 'post-preview') return;
+    // The copy must not steal ids from the real message.
+    preview.querySelectorAll('[id]').forEach(el => el.removeAttribute('id'));
+
+    const rect = e.detail.elt.getBoundingClientRect();
+    preview.style.left = Math.max(8, Math.min(rect.left, window.innerWidth - 508)) + 'px';
+    preview.style.top = '';
+    preview.style.bottom = '';
+    if (rect.top > window.innerHeight / 2) {
+        preview.style.bottom = (window.innerHeight - rect.top + 6) + 'px';
+    } else {
+        preview.style.top = (rect.bottom + 6) + 'px';
+    }
+    preview.hidden = false;
+});
+
+function hidePostPreview() {
+    postPreview.link = null;
+    document.getElementById('post-preview').hidden = true;
+}
+
+function highlightMessage(message) {
+    message.scrollIntoView({ behavior: 'smooth', block: 'center' });
+    message.classList.remove('reply-highlight');
+    void message.offsetWidth; // restart the animation
+    message.classList.add('reply-highlight');
+}
+
+// Heartbeats keep the session alive while the tab is open and tell the
+// server whether the user is actually looking at it.
+let chatSocket = null;
+let heartbeatTimer = null;
+
+function sendHeartbeat() {
+    if (chatSocket) {
+        chatSocket.send(JSON.stringify({ v: 1, type: 'heartbeat', focused: document.hasFocus() }));
+    }
+}
+
+document.addEventListener('htmx:wsOpen', function (e) {
+    chatSocket = e.detail.socketWrapper;
+
+    // Must be the first frame: the server holds live messages back until it
+    // knows what this page has already seen.
+    const seq = document.getElementById('ws-seq');
+    chatSocket.send(JSON.stringify({ v: 1, type: 'resume', seq: parseInt(seq.dataset.seq, 10) || 0 }));
+
+    sendHeartbeat();
+
+    const container = document.querySelector('.chat-container');
+    const interval = parseInt(container.dataset.heartbeatMs, 10) || 10000;
+    clearInterval(heartbeatTimer);
+    heartbeatTimer = setInterval(sendHeartbeat, interval);
+});
+
+document.addEventListener('htmx:wsClose', function () {
+    chatSocket = null;
+    clearInterval(heartbeatTimer);
+    typing.active = false;
+});
+
+// Typing indicators: "typing" frames are repeated at most every
+// TYPING_REPEAT_MS while the user keeps typing (the server forgets them
+// after a few seconds) and a stop frame follows a pause or a send.
+const TYPING_REPEAT_MS = 2000;
+const TYPING_IDLE_MS = 3000;
+const typing = { active: false, sentAt: 0, idleTimer: null };
+
+function sendTyping(active) {
+    if (!chatSocket) return;
+    chatSocket.send(JSON.stringify({ v: 1, type: 'typing', typing: active }));
+    typing.active = active;
+    typing.sentAt = Date.now();
+}
+
+function stopTyping() {
+    clearTimeout(typing.idleTimer);
+    if (typing.active) sendTyping(false);
+}
+
+document.addEventListener('input', function (e) {
+    if (e.target.id !== 'message-input') return;
+
+    if (!e.target.value) {
+        stopTyping();
+        return;
+    }
+    if (!typing.active || Date.now() - typing.sentAt > TYPING_REPEAT_MS) {
+        sendTyping(true);
+    }
+    clearTimeout(typing.idleTimer);
+    typing.idleTimer = setTimeout(stopTyping, TYPING_IDLE_MS);
+});
+
+document.addEventListener('submit', function (e) {
+    if (e.target.classList.contains('chat-form')) stopTyping();
+});
+
+window.addEventListener('focus', sendHeartbeat);
+window.addEventListener('blur', sendHeartbeat);
+
 document.addEventListener('DOMContentLoaded', function () {
     const chatInput = document.querySelector('input[name="chat_message"]');
     const errorContainer = document.getElementById('error-container');
@@ -43,56 +418,41 @@ document.addEventListener('DOMContentLoaded', function () {
         });
     }
 
-    // highlightRepliedMessages();
     initializeFilePreview();
-    //setupDeleteButtons();
-});
-
-/*
-function highlightRepliedMessages() {
-    const messages = document.querySelectorAll('.message');
-
-    messages.forEach(message => {
-        const content = message.textContent;
-        const userIdElement = message.querySelector('.user-id-tooltip');
-
-        if (!userIdElement) return;
-
-        const fullUserId = userIdElement.textContent.replace('ID: ', '');
-        const shortUserId = fullUserId.substring(0, 4);
 
-        const mentionPattern = new RegExp('@' + shortUserId + '\\b');
-        let isRepliedTo = false;
-
-        messages.forEach(otherMessage => {
-            if (otherMessage !== message) {
-                const otherContent = otherMessage.textContent;
-                if (mentionPattern.test(otherContent)) {
-                    isRepliedTo = true;
-                }
-            }
-        });
+    const linked = location.hash.startsWith('#msg-') && document.querySelector(location.hash);
+    if (linked) {
+        highlightMessage(linked);
+    }
+});
 
-        if (isRepliedTo) {
-            message.classList.add('reply-highlight');
+// A message posted while the page was loading can arrive both in the page
+// and in the replay after connecting; keep the first copy.
+function removeDuplicateMessages() {
+    const seen = new Set();
+    document.querySelectorAll('#messages .message').forEach(message => {
+        if (seen.has(message.id)) {
+            message.remove();
         } else {
-            message.classList.remove('reply-highlight');
+            seen.add(message.id);
         }
     });
 }
-    */
 
 function initializeFilePreview() {
     const fileInput = document.getElementById('file-input');
     const filePreview = document.getElementById('file-preview');
     
     if (fileInput && filePreview) {
+        // The server's uploads.max_size, so oversized files never get sent.
+        const maxSize = Number(fileInput.form.dataset.maxUploadSize);
+
         fileInput.addEventListener('change', function(e) {
             const file = e.target.files[0];
             
             if (file) {
-                if (file.size > 5 * 1024 * 1024) {
-                    alert('File too large! Maximum size is 5MB.');
+                if (file.size > maxSize) {
+                    alert('File too large! Maximum size is ' + formatSize(maxSize) + '.');
                     fileInput.value = '';
                     clearFilePreview();
                     return;
@@ -127,6 +487,19 @@ function initializeFilePreview() {
     }
 }
 
+// formatSize describes a size in bytes like the server does, e.g. "5MB" or
+// "1.5MB".
+function formatSize(bytes) {
+    let size = bytes;
+    let unit = 'B';
+    for (const next of ['KB', 'MB', 'GB']) {
+        if (size < 1024) break;
+        size /= 1024;
+        unit = next;
+    }
+    return (Number.isInteger(size) ? size : size.toFixed(1)) + unit;
+}
+
 function clearFilePreview() {
     const fileInput = document.getElementById('file-input');
     const filePreview = document.getElementById('file-preview');
@@ -148,7 +521,6 @@ function scrollToBottom() {
 document.body.addEventListener('htmx:afterSwap', function(evt) {
     if (evt.target && evt.target.id === 'messages') {
         scrollToBottom();
-        highlightRepliedMessages();
     }
 });
 
@@ -189,41 +561,4 @@ document.body.addEventListener('htmx:afterSettle', function(evt) {
     if (evt.target && (evt.target.id === 'file-input' || evt.target.name === 'image')) {
         initializeFilePreview();
     }
-    
-    setupDeleteButtons();
 });
-
-/*
-function setupDeleteButtons() {
-    const userIdElement = document.querySelector('.user-info .user-id');
-    if (!userIdElement) {
-        console.log('Could not find current user ID element');
-        return;
-    }
-    
-    const currentUserShortId = userIdElement.textContent.trim();
-    console.log('Current user short ID:', currentUserShortId);
-    
-    const deleteButtons = document.querySelectorAll('.delete-message-btn');
-    console.log('Found delete buttons:', deleteButtons.length);
-    
-    deleteButtons.forEach(button => {
-        const message = button.closest('.message');
-        if (message) {
-            const messageUserId = message.dataset.userId;
-            const messageUserShortId = messageUserId ? messageUserId.substring(0, 4) : '';
-            
-            console.log('Message user ID:', messageUserId, 'Short:', messageUserShortId);
-            console.log('Current user short ID:', currentUserShortId);
-            console.log('Match:', messageUserShortId === currentUserShortId);
-            
-            if (messageUserShortId === currentUserShortId) {
-                button.style.display = 'none';
-                button.dataset.isOwn = 'true';
-            } else {
-                button.remove();
-            }
-        }
-    });
-}
-    */
\ No newline at end of file
@@ -0,0 +1,635 @@
+package store
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"temp0ral-chat/models"
+	"time"
+)
+
+// Memory keeps each room's messages in a ring buffer, which only grows past
+// the history limit to make room for pinned messages. Nothing touches disk,
+// so a restart loses everything, which is exactly what an ephemeral chat
+// wants.
+type Memory struct {
+	mutex    sync.RWMutex
+	capacity int
+	rooms    map[string]*memoryRoom
+	nextID   int
+
+	// sessions holds the registered sessions by user ID.
+	sessions map[string]models.Session
+
+	// revisions holds the earlier versions of edited messages by message ID.
+	revisions map[int][]models.Revision
+	// replies maps a message ID to the IDs of the messages replying to it.
+	replies map[int][]int
+	// reactions holds each message's reactions by message ID.
+	reactions map[int][]models.Reaction
+
+	// directs holds every direct message, oldest first.
+	directs      []models.DirectMessage
+	nextDirectID int
+
+	// evicted holds messages overwritten by Insert once a ring is full,
+	// handed back by the next TrimToLimit so their images get deleted.
+	evicted []models.Message
+}
+
+type memoryRoom struct {
+	room  models.Room
+	ring  []models.Message
+	start int
+	count int
+}
+
+func NewMemory(capacity int) *Memory {
+	return &Memory{
+		capacity:  capacity,
+		rooms:     make(map[string]*memoryRoom),
+		sessions:  make(map[string]models.Session),
+		revisions: make(map[int][]models.Revision),
+		replies:   make(map[int][]int),
+		reactions: make(map[int][]models.Reaction),
+	}
+}
+
+func (m *Memory) CreateRoom(room models.Room) (models.Room, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.rooms[room.ID]; ok {
+		return models.Room{}, ErrRoomExists
+	}
+	room.CreatedAt = time.Now()
+	m.rooms[room.ID] = &memoryRoom{room: room, ring: make([]models.Message, m.capacity)}
+	return room, nil
+}
+
+func (m *Memory) Room(id string) (models.Room, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	r, ok := m.rooms[id]
+	if !ok {
+		return models.Room{}, ErrRoomNotFound
+	}
+	return r.room, nil
+}
+
+func (m *Memory) Rooms() ([]models.Room, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	rooms := make([]models.Room, 0, len(m.rooms))
+	for _, r := range m.rooms {
+		rooms = append(rooms, r.room)
+	}
+	slices.SortFunc(rooms, func(a, b models.Room) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return rooms, nil
+}
+
+func (m *Memory) DeleteRoom(id string) ([]models.Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	r, ok := m.rooms[id]
+	if !ok {
+		return nil, nil
+	}
+	delete(m.rooms, id)
+	return m.removeFromLocked(r, func(models.Message) bool { return true }), nil
+}
+
+func (m *Memory) AddSession(session models.Session) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.sessions[session.UserID] = session
+	return nil
+}
+
+func (m *Memory) EndSession(userID string) ([]models.Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.sessions, userID)
+	m.directs = slices.DeleteFunc(m.directs, func(dm models.DirectMessage) bool {
+		return dm.SenderID == userID || dm.RecipientID == userID
+	})
+	m.dropReactionsLocked(func(id string) bool { return id == userID })
+	return m.removeLocked(func(msg models.Message) bool {
+		return msg.UserID == userID
+	}), nil
+}
+
+func (m *Memory) PruneSessions(activeUserIDs []string, createdBefore time.Time) ([]models.Message, error) {
+	keep := make(map[string]bool, len(activeUserIDs))
+	for _, userID := range activeUserIDs {
+		keep[userID] = true
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for userID, session := range m.sessions {
+		if !session.CreatedAt.Before(createdBefore) {
+			keep[userID] = true
+		} else if !keep[userID] {
+			delete(m.sessions, userID)
+		}
+	}
+	m.directs = slices.DeleteFunc(m.directs, func(dm models.DirectMessage) bool {
+		return !keep[dm.SenderID] || !keep[dm.RecipientID]
+	})
+	m.dropReactionsLocked(func(userID string) bool { return !keep[userID] })
+	return m.removeLocked(func(msg models.Message) bool {
+		return !keep[msg.UserID]
+	}), nil
+}
+
+func (m *Memory) Insert(msg models.Message) (models.Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.sessions[msg.UserID]; !ok {
+		return models.Message{}, ErrNoSession
+	}
+	r, ok := m.rooms[msg.RoomID]
+	if !ok {
+		return models.Message{}, ErrRoomNotFound
+	}
+
+	m.nextID++
+	msg.ID = m.nextID
+	msg.CreatedAt = time.Now()
+
+	var replyTo []int
+	for _, id := range msg.ReplyTo {
+		if r.index(id) >= 0 {
+			replyTo = append(replyTo, id)
+			m.replies[id] = append(m.replies[id], msg.ID)
+		}
+	}
+	msg.ReplyTo = replyTo
+
+	if r.count == len(r.ring) {
+		m.makeRoomLocked(r)
+	}
+	r.ring[(r.start+r.count)%len(r.ring)] = msg
+	r.count++
+	return msg, nil
+}
+
+func (m *Memory) InsertDirect(dm models.DirectMessage) (models.DirectMessage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.sessions[dm.SenderID]; !ok {
+		return models.DirectMessage{}, ErrNoSession
+	}
+	if _, ok := m.sessions[dm.RecipientID]; !ok {
+		return models.DirectMessage{}, ErrNoSession
+	}
+
+	m.nextDirectID++
+	dm.ID = m.nextDirectID
+	dm.CreatedAt = time.Now()
+	m.directs = append(m.directs, dm)
+
+	// Keep each conversation to the same length as a room's history.
+	if conversation := m.conversationLocked(dm.SenderID, dm.RecipientID); len(conversation) > m.capacity {
+		oldest := conversation[0].ID
+		m.directs = slices.DeleteFunc(m.directs, func(d models.DirectMessage) bool {
+			return d.ID == oldest
+		})
+	}
+	return dm, nil
+}
+
+func (m *Memory) Conversation(userID, peerID string, limit int) ([]models.DirectMessage, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	conversation := m.conversationLocked(userID, peerID)
+	if len(conversation) > limit {
+		conversation = conversation[len(conversation)-limit:]
+	}
+	return conversation, nil
+}
+
+// conversationLocked returns the direct messages between two users, oldest
+// first. Callers must hold the mutex.
+func (m *Memory) conversationLocked(userID, peerID string) []models.DirectMessage {
+	var conversation []models.DirectMessage
+	for _, dm := range m.directs {
+		if dm.SenderID == userID && dm.RecipientID == peerID ||
+			dm.SenderID == peerID && dm.RecipientID == userID {
+			conversation = append(conversation, dm)
+		}
+	}
+	return conversation
+}
+
+func (m *Memory) Get(id int) (models.Message, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if msg := m.findLocked(id); msg != nil {
+		return m.withDetails(*msg), nil
+	}
+	return models.Message{}, ErrNotFound
+}
+
+func (m *Memory) Visible(id int) (models.Message, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	msg := m.findLocked(id)
+	if msg == nil || !m.liveLocked(msg.UserID, time.Now()) {
+		return models.Message{}, ErrNotFound
+	}
+	return m.withDetails(*msg), nil
+}
+
+func (m *Memory) Recent(roomID string, limit int) ([]models.Message, error) {
+	return m.Before(roomID, math.MaxInt, limit)
+}
+
+func (m *Memory) Before(roomID string, beforeID, limit int) ([]models.Message, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	r, ok := m.rooms[roomID]
+	if !ok {
+		return nil, nil
+	}
+
+	now := time.Now()
+	var messages []models.Message
+	for i := 0; i < r.count; i++ {
+		msg := r.at(i)
+		if msg.ID < beforeID && m.liveLocked(msg.UserID, now) {
+			messages = append(messages, m.withDetails(msg))
+		}
+	}
+	if len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+	return messages, nil
+}
+
+func (m *Memory) After(roomID string, afterID, limit int) ([]models.Message, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	r, ok := m.rooms[roomID]
+	if !ok {
+		return nil, nil
+	}
+
+	now := time.Now()
+	var messages []models.Message
+	for i := 0; i < r.count && len(messages) < limit; i++ {
+		msg := r.at(i)
+		if msg.ID > afterID && m.liveLocked(msg.UserID, now) {
+			messages = append(messages, m.withDetails(msg))
+		}
+	}
+	return messages, nil
+}
+
+func (m *Memory) Search(roomID string, terms []string, window, limit int) ([]models.Message, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	recent, err := m.Recent(roomID, window)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []models.Message
+	for i := len(recent) - 1; i >= 0 && len(results) < limit; i-- {
+		if matchesSearch(recent[i].Content, terms) {
+			results = append(results, recent[i])
+		}
+	}
+	return results, nil
+}
+
+func (m *Memory) Edit(id int, content string, replyTo []int, mentions []string) (models.Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	msg := m.findLocked(id)
+	if msg == nil {
+		return models.Message{}, ErrNotFound
+	}
+
+	for _, target := range msg.ReplyTo {
+		m.replies[target] = slices.DeleteFunc(m.replies[target], func(reply int) bool {
+			return reply == id
+		})
+	}
+	r := m.rooms[msg.RoomID]
+	msg.ReplyTo = nil
+	for _, target := range replyTo {
+		if r.index(target) >= 0 {
+			msg.ReplyTo = append(msg.ReplyTo, target)
+			m.replies[target] = append(m.replies[target], id)
+		}
+	}
+	msg.Mentions = mentions
+
+	now := time.Now()
+	m.revisions[id] = append(m.revisions[id], models.Revision{
+		MessageID:  id,
+		Content:    msg.Content,
+		ReplacedAt: now,
+	})
+	msg.Content = content
+	msg.EditedAt = now
+	return m.withDetails(*msg), nil
+}
+
+func (m *Memory) ToggleReaction(id int, userID, emoji string) (models.Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	msg := m.findLocked(id)
+	if msg == nil {
+		return models.Message{}, ErrNotFound
+	}
+	if _, ok := m.sessions[userID]; !ok {
+		return models.Message{}, ErrNoSession
+	}
+
+	reactions := m.reactions[id]
+	i := slices.IndexFunc(reactions, func(r models.Reaction) bool { return r.Emoji == emoji })
+	switch {
+	case i < 0:
+		reactions = append(reactions, models.Reaction{Emoji: emoji, UserIDs: []string{userID}})
+	case slices.Contains(reactions[i].UserIDs, userID):
+		reactions[i].UserIDs = slices.DeleteFunc(reactions[i].UserIDs, func(u string) bool { return u == userID })
+		if len(reactions[i].UserIDs) == 0 {
+			reactions = slices.Delete(reactions, i, i+1)
+		}
+	default:
+		reactions[i].UserIDs = append(reactions[i].UserIDs, userID)
+	}
+	if len(reactions) == 0 {
+		delete(m.reactions, id)
+	} else {
+		m.reactions[id] = reactions
+	}
+	return m.withDetails(*msg), nil
+}
+
+func (m *Memory) Pin(id int, pinned bool) (models.Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	msg := m.findLocked(id)
+	if msg == nil {
+		return models.Message{}, ErrNotFound
+	}
+	switch {
+	case !pinned:
+		msg.PinnedAt = time.Time{}
+	case !msg.Pinned():
+		msg.PinnedAt = time.Now()
+	}
+	return m.withDetails(*msg), nil
+}
+
+func (m *Memory) Pinned(roomID string) ([]models.Message, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	r, ok := m.rooms[roomID]
+	if !ok {
+		return nil, nil
+	}
+
+	now := time.Now()
+	var pinned []models.Message
+	for i := 0; i < r.count; i++ {
+		msg := r.at(i)
+		if msg.Pinned() && m.liveLocked(msg.UserID, now) {
+			pinned = append(pinned, m.withDetails(msg))
+		}
+	}
+	slices.SortFunc(pinned, func(a, b models.Message) int {
+		return a.PinnedAt.Compare(b.PinnedAt)
+	})
+	return pinned, nil
+}
+
+func (m *Memory) Revisions(id int) ([]models.Revision, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return append([]models.Revision(nil), m.revisions[id]...), nil
+}
+
+func (m *Memory) Delete(id int) (models.Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	deleted := m.removeLocked(func(msg models.Message) bool {
+		return msg.ID == id
+	})
+	if len(deleted) == 0 {
+		return models.Message{}, ErrNotFound
+	}
+	return deleted[0], nil
+}
+
+func (m *Memory) DeleteAll() ([]models.Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	clear(m.sessions)
+	m.directs = nil
+	return m.removeLocked(func(models.Message) bool { return true }), nil
+}
+
+func (m *Memory) TrimToLimit(limit int) ([]models.Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	removed := m.evicted
+	m.evicted = nil
+
+	for _, r := range m.rooms {
+		unpinned := 0
+		for i := 0; i < r.count; i++ {
+			if !r.at(i).Pinned() {
+				unpinned++
+			}
+		}
+		if excess := unpinned - limit; excess > 0 {
+			seen := 0
+			removed = append(removed, m.removeFromLocked(r, func(msg models.Message) bool {
+				if msg.Pinned() {
+					return false
+				}
+				seen++
+				return seen <= excess
+			})...)
+		}
+	}
+	return removed, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// at returns the i-th oldest message of the room.
+func (r *memoryRoom) at(i int) models.Message {
+	return r.ring[(r.start+i)%len(r.ring)]
+}
+
+// index returns the position of message id for at, or -1 if it is not in
+// the room.
+func (r *memoryRoom) index(id int) int {
+	for i := 0; i < r.count; i++ {
+		if r.at(i).ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// makeRoomLocked frees a slot in the full ring r. Pinned messages do not
+// count towards the capacity, so the ring grows while they take up slots;
+// otherwise the oldest message that is not pinned is evicted. Callers must
+// hold the mutex.
+func (m *Memory) makeRoomLocked(r *memoryRoom) {
+	pinned, victim := 0, -1
+	for i := 0; i < r.count; i++ {
+		if r.at(i).Pinned() {
+			pinned++
+		} else if victim < 0 {
+			victim = i
+		}
+	}
+
+	if r.count-pinned < m.capacity {
+		ring := make([]models.Message, m.capacity+pinned)
+		for i := 0; i < r.count; i++ {
+			ring[i] = r.at(i)
+		}
+		r.ring, r.start = ring, 0
+		return
+	}
+
+	oldest := r.at(victim)
+	m.evicted = append(m.evicted, oldest)
+	if victim > 0 {
+		m.removeFromLocked(r, func(msg models.Message) bool { return msg.ID == oldest.ID })
+		return
+	}
+	m.forgetLocked(oldest)
+	r.ring[r.start] = models.Message{}
+	r.start = (r.start + 1) % len(r.ring)
+	r.count--
+}
+
+// findLocked returns the stored message id, whichever room it is in, or
+// nil. Callers must hold the mutex.
+func (m *Memory) findLocked(id int) *models.Message {
+	for _, r := range m.rooms {
+		if i := r.index(id); i >= 0 {
+			return &r.ring[(r.start+i)%len(r.ring)]
+		}
+	}
+	return nil
+}
+
+// liveLocked reports whether userID has a session that has not expired by
+// now. Callers must hold the mutex.
+func (m *Memory) liveLocked(userID string, now time.Time) bool {
+	session, ok := m.sessions[userID]
+	return ok && now.Before(session.ExpiresAt)
+}
+
+// withDetails returns a copy of msg with its Replies and Reactions filled
+// in. Callers must hold the mutex.
+func (m *Memory) withDetails(msg models.Message) models.Message {
+	msg.Replies = append([]int(nil), m.replies[msg.ID]...)
+	msg.Reactions = nil
+	for _, r := range m.reactions[msg.ID] {
+		r.UserIDs = append([]string(nil), r.UserIDs...)
+		msg.Reactions = append(msg.Reactions, r)
+	}
+	return msg
+}
+
+// dropReactionsLocked removes every reaction by a user matching gone.
+// Callers must hold the mutex.
+func (m *Memory) dropReactionsLocked(gone func(userID string) bool) {
+	for id, reactions := range m.reactions {
+		for i := range reactions {
+			reactions[i].UserIDs = slices.DeleteFunc(reactions[i].UserIDs, gone)
+		}
+		reactions = slices.DeleteFunc(reactions, func(r models.Reaction) bool {
+			return len(r.UserIDs) == 0
+		})
+		if len(reactions) == 0 {
+			delete(m.reactions, id)
+		} else {
+			m.reactions[id] = reactions
+		}
+	}
+}
+
+// forgetLocked drops the revisions and reply links of a message that is
+// leaving its ring. Callers must hold the mutex.
+func (m *Memory) forgetLocked(msg models.Message) {
+	delete(m.revisions, msg.ID)
+	delete(m.replies, msg.ID)
+	delete(m.reactions, msg.ID)
+	for _, target := range msg.ReplyTo {
+		m.replies[target] = slices.DeleteFunc(m.replies[target], func(id int) bool {
+			return id == msg.ID
+		})
+	}
+}
+
+// removeLocked drops every message matching pred from every room. Callers
+// must hold the mutex.
+func (m *Memory) removeLocked(pred func(models.Message) bool) []models.Message {
+	var removed []models.Message
+	for _, r := range m.rooms {
+		removed = append(removed, m.removeFromLocked(r, pred)...)
+	}
+	return removed
+}
+
+// removeFromLocked drops every message in r matching pred, oldest first, and
+// compacts the survivors to the front of its ring. Callers must hold the
+// mutex.
+func (m *Memory) removeFromLocked(r *memoryRoom, pred func(models.Message) bool) []models.Message {
+	var removed []models.Message
+	kept := make([]models.Message, 0, r.count)
+	for i := 0; i < r.count; i++ {
+		msg := r.at(i)
+		if pred(msg) {
+			removed = append(removed, msg)
+			m.forgetLocked(msg)
+		} else {
+			kept = append(kept, msg)
+		}
+	}
+
+	clear(r.ring)
+	copy(r.ring, kept)
+	r.start = 0
+	r.count = len(kept)
+	return removed
+}
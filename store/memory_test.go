@@ -0,0 +1,275 @@
+package store
+
+import (
+	"math"
+	"slices"
+	"temp0ral-chat/models"
+	"testing"
+	"time"
+)
+
+// newTestMemory returns a Memory store holding room "lobby" and a live
+// session for each of userIDs.
+func newTestMemory(t *testing.T, capacity int, userIDs ...string) *Memory {
+	t.Helper()
+	m := NewMemory(capacity)
+	if _, err := m.CreateRoom(models.Room{ID: "lobby"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, userID := range userIDs {
+		addTestSession(t, m, userID, time.Now())
+	}
+	return m
+}
+
+func addTestSession(t *testing.T, m *Memory, userID string, createdAt time.Time) {
+	t.Helper()
+	err := m.AddSession(models.Session{
+		ID:        "session-" + userID,
+		UserID:    userID,
+		CreatedAt: createdAt,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func insertTest(t *testing.T, m *Memory, userID, content string, replyTo ...int) models.Message {
+	t.Helper()
+	msg, err := m.Insert(models.Message{RoomID: "lobby", UserID: userID, Content: content, ReplyTo: replyTo})
+	if err != nil {
+		t.Fatalf("Insert(%q): %v", content, err)
+	}
+	return msg
+}
+
+func messageIDs(messages []models.Message) []int {
+	ids := make([]int, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+	return ids
+}
+
+func checkIDs(t *testing.T, what string, messages []models.Message, err error, want ...int) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+	if got := messageIDs(messages); !slices.Equal(got, want) {
+		t.Errorf("%s = %v, want %v", what, got, want)
+	}
+}
+
+func TestMemoryRingWrapsAround(t *testing.T) {
+	m := newTestMemory(t, 3, "a")
+	for _, content := range []string{"1", "2", "3", "4", "5"} {
+		insertTest(t, m, "a", content)
+	}
+
+	recent, err := m.Recent("lobby", 10)
+	checkIDs(t, "Recent", recent, err, 3, 4, 5)
+
+	// The messages Insert overwrote are handed back once, for their images.
+	trimmed, err := m.TrimToLimit(3)
+	checkIDs(t, "first TrimToLimit", trimmed, err, 1, 2)
+	trimmed, err = m.TrimToLimit(3)
+	checkIDs(t, "second TrimToLimit", trimmed, err)
+
+	trimmed, err = m.TrimToLimit(2)
+	checkIDs(t, "TrimToLimit(2)", trimmed, err, 3)
+	recent, err = m.Recent("lobby", 10)
+	checkIDs(t, "Recent after trim", recent, err, 4, 5)
+
+	// The ring keeps wrapping after being compacted by the trim.
+	insertTest(t, m, "a", "6")
+	insertTest(t, m, "a", "7")
+	recent, err = m.Recent("lobby", 10)
+	checkIDs(t, "Recent after wrapping again", recent, err, 5, 6, 7)
+}
+
+// sessionFixture has a and b talk: b replies to a and a to b, a reacts to
+// b's message and edits their own, and they exchange a direct message.
+type sessionFixture struct {
+	fromA, fromB, replyFromA models.Message
+}
+
+func newSessionFixture(t *testing.T, m *Memory) sessionFixture {
+	t.Helper()
+	var f sessionFixture
+	f.fromA = insertTest(t, m, "a", "hello")
+	f.fromB = insertTest(t, m, "b", ">>1 hi", f.fromA.ID)
+	f.replyFromA = insertTest(t, m, "a", ">>2 how are you", f.fromB.ID)
+	if _, err := m.Edit(f.fromA.ID, "hello there", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ToggleReaction(f.fromB.ID, "a", "👍"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.InsertDirect(models.DirectMessage{SenderID: "a", RecipientID: "b", Content: "psst"}); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// checkSessionGone checks that nothing of a is left after their session
+// ended, and that b's message is still there without a's traces.
+func checkSessionGone(t *testing.T, m *Memory, f sessionFixture) {
+	t.Helper()
+	recent, err := m.Recent("lobby", 10)
+	checkIDs(t, "Recent", recent, err, f.fromB.ID)
+
+	if revisions, _ := m.Revisions(f.fromA.ID); len(revisions) > 0 {
+		t.Errorf("Revisions of a removed message = %v, want none", revisions)
+	}
+	kept, err := m.Get(f.fromB.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept.Replies) > 0 {
+		t.Errorf("Replies = %v, want the removed reply gone", kept.Replies)
+	}
+	if len(kept.Reactions) > 0 {
+		t.Errorf("Reactions = %v, want the removed user's reaction gone", kept.Reactions)
+	}
+	if dms, _ := m.Conversation("b", "a", 10); len(dms) > 0 {
+		t.Errorf("Conversation = %v, want none", dms)
+	}
+	if _, err := m.Insert(models.Message{RoomID: "lobby", UserID: "a", Content: "still here?"}); err != ErrNoSession {
+		t.Errorf("Insert for an ended session: err = %v, want ErrNoSession", err)
+	}
+}
+
+func TestMemoryEndSession(t *testing.T) {
+	m := newTestMemory(t, 10, "a", "b")
+	f := newSessionFixture(t, m)
+
+	removed, err := m.EndSession("a")
+	checkIDs(t, "EndSession", removed, err, f.fromA.ID, f.replyFromA.ID)
+	checkSessionGone(t, m, f)
+}
+
+func TestMemoryPruneSessions(t *testing.T) {
+	m := newTestMemory(t, 10, "a", "b")
+	f := newSessionFixture(t, m)
+
+	// c signs in after the active list was read, so is not on it.
+	cutoff := time.Now()
+	addTestSession(t, m, "c", cutoff.Add(time.Millisecond))
+	fromC := insertTest(t, m, "c", "just arrived")
+
+	removed, err := m.PruneSessions([]string{"b"}, cutoff)
+	checkIDs(t, "PruneSessions", removed, err, f.fromA.ID, f.replyFromA.ID)
+
+	recent, err := m.Recent("lobby", 10)
+	checkIDs(t, "Recent", recent, err, f.fromB.ID, fromC.ID)
+	if _, err := m.EndSession("c"); err != nil {
+		t.Fatal(err)
+	}
+	checkSessionGone(t, m, f)
+}
+
+func TestMemoryBeforeAndAfterPage(t *testing.T) {
+	m := newTestMemory(t, 10, "a", "b")
+	for i := 1; i <= 7; i++ {
+		insertTest(t, m, "a", "message")
+	}
+	// b's message disappears from every page once their session ends.
+	fromB := insertTest(t, m, "b", "gone soon")
+	if _, err := m.EndSession("b"); err != nil {
+		t.Fatal(err)
+	}
+	insertTest(t, m, "a", "last")
+
+	pages := []struct {
+		beforeID int
+		want     []int
+	}{
+		{math.MaxInt, []int{6, 7, 9}},
+		{6, []int{3, 4, 5}},
+		{3, []int{1, 2}},
+		{1, nil},
+	}
+	for _, p := range pages {
+		page, err := m.Before("lobby", p.beforeID, 3)
+		checkIDs(t, "Before", page, err, p.want...)
+	}
+
+	afterPages := []struct {
+		afterID int
+		want    []int
+	}{
+		{0, []int{1, 2, 3}},
+		{3, []int{4, 5, 6}},
+		{6, []int{7, 9}},
+		{9, nil},
+	}
+	for _, p := range afterPages {
+		page, err := m.After("lobby", p.afterID, 3)
+		checkIDs(t, "After", page, err, p.want...)
+	}
+
+	page, err := m.After("lobby", fromB.ID-1, 1)
+	checkIDs(t, "After an ended session's message", page, err, fromB.ID+1)
+	if page, _ := m.Before("nowhere", math.MaxInt, 3); page != nil {
+		t.Errorf("Before in a missing room = %v, want nil", messageIDs(page))
+	}
+}
+
+func TestMemoryPinsGrowRing(t *testing.T) {
+	m := newTestMemory(t, 2, "a")
+	pinned := insertTest(t, m, "a", "rules")
+	if _, err := m.Pin(pinned.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pin does not count towards the capacity of 2.
+	insertTest(t, m, "a", "2")
+	insertTest(t, m, "a", "3")
+	recent, err := m.Recent("lobby", 10)
+	checkIDs(t, "Recent with a pin", recent, err, 1, 2, 3)
+	if r := m.rooms["lobby"]; len(r.ring) != 3 {
+		t.Errorf("ring length = %d, want it grown to 3", len(r.ring))
+	}
+
+	// A full ring evicts the oldest message that is not pinned.
+	insertTest(t, m, "a", "4")
+	recent, err = m.Recent("lobby", 10)
+	checkIDs(t, "Recent after eviction", recent, err, 1, 3, 4)
+	trimmed, err := m.TrimToLimit(2)
+	checkIDs(t, "TrimToLimit", trimmed, err, 2)
+
+	// Once unpinned the message counts again and is the oldest.
+	if _, err := m.Pin(pinned.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	trimmed, err = m.TrimToLimit(2)
+	checkIDs(t, "TrimToLimit after unpinning", trimmed, err, 1)
+	recent, err = m.Recent("lobby", 10)
+	checkIDs(t, "Recent after unpinning", recent, err, 3, 4)
+}
+
+func TestMemoryEditRelinksReplies(t *testing.T) {
+	m := newTestMemory(t, 10, "a", "b")
+	first := insertTest(t, m, "a", "first")
+	second := insertTest(t, m, "a", "second")
+	reply := insertTest(t, m, "b", ">>1", first.ID)
+
+	edited, err := m.Edit(reply.ID, ">>2 @a >>99", []int{second.ID, 99}, []string{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(edited.ReplyTo, []int{second.ID}) {
+		t.Errorf("ReplyTo = %v, want [%d] without the missing message", edited.ReplyTo, second.ID)
+	}
+	if !slices.Equal(edited.Mentions, []string{"a"}) {
+		t.Errorf("Mentions = %v, want [a]", edited.Mentions)
+	}
+	if got, _ := m.Get(first.ID); len(got.Replies) > 0 {
+		t.Errorf("Replies of the message no longer quoted = %v, want none", got.Replies)
+	}
+	if got, _ := m.Get(second.ID); !slices.Equal(got.Replies, []int{reply.ID}) {
+		t.Errorf("Replies of the message now quoted = %v, want [%d]", got.Replies, reply.ID)
+	}
+}
@@ -0,0 +1,706 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"temp0ral-chat/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const foreignKeyViolation = "23503"
+
+const messageColumns = "id, room_id, username, content, created_at, user_id, image_path, edited_at, mentions, pinned_at, kind"
+
+const roomColumns = "id, created_at, access_key, created_by"
+
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) CreateRoom(room models.Room) (models.Room, error) {
+	err := p.db.QueryRow(
+		"INSERT INTO rooms (id, access_key, created_by) VALUES ($1, $2, $3) ON CONFLICT (id) DO NOTHING RETURNING created_at",
+		room.ID, nullString(room.AccessKey), nullString(room.CreatedBy),
+	).Scan(&room.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Room{}, ErrRoomExists
+	}
+	if err != nil {
+		return models.Room{}, err
+	}
+	return room, nil
+}
+
+func (p *Postgres) Room(id string) (models.Room, error) {
+	room, err := scanRoom(p.db.QueryRow("SELECT "+roomColumns+" FROM rooms WHERE id = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Room{}, ErrRoomNotFound
+	}
+	if err != nil {
+		return models.Room{}, err
+	}
+	return room, nil
+}
+
+func (p *Postgres) Rooms() ([]models.Room, error) {
+	rows, err := p.db.Query("SELECT " + roomColumns + " FROM rooms ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []models.Room
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, rows.Err()
+}
+
+// DeleteRoom deletes the room in one statement; the foreign key cascades to
+// its messages, which the CTE reads as they were just before.
+func (p *Postgres) DeleteRoom(id string) ([]models.Message, error) {
+	rows, err := p.db.Query(`
+		WITH gone AS (
+			DELETE FROM rooms WHERE id = $1 RETURNING id
+		)
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE room_id IN (SELECT id FROM gone)
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+func (p *Postgres) AddSession(session models.Session) error {
+	_, err := p.db.Exec(
+		"INSERT INTO sessions (user_id, created_at, expires_at) VALUES ($1, $2, $3)",
+		session.UserID, session.CreatedAt, session.ExpiresAt,
+	)
+	return err
+}
+
+func (p *Postgres) EndSession(userID string) ([]models.Message, error) {
+	return p.deleteSessions("user_id = $1", userID)
+}
+
+func (p *Postgres) PruneSessions(activeUserIDs []string, createdBefore time.Time) ([]models.Message, error) {
+	// A nil slice would be sent as NULL, which matches nothing.
+	if activeUserIDs == nil {
+		activeUserIDs = []string{}
+	}
+	return p.deleteSessions("NOT (user_id = ANY($1)) AND created_at < $2", pq.Array(activeUserIDs), createdBefore)
+}
+
+// deleteSessions deletes the sessions matching where in one statement; the
+// foreign key cascades to their messages. The CTE reads the messages from
+// the statement's snapshot, i.e. as they were just before the cascade.
+func (p *Postgres) deleteSessions(where string, args ...interface{}) ([]models.Message, error) {
+	rows, err := p.db.Query(`
+		WITH ended AS (
+			DELETE FROM sessions WHERE `+where+` RETURNING user_id
+		)
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE user_id IN (SELECT user_id FROM ended)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+func (p *Postgres) Insert(msg models.Message) (models.Message, error) {
+	var imagePath interface{}
+	if msg.ImagePath != "" {
+		imagePath = msg.ImagePath
+	}
+	// A nil slice would be sent as NULL, which the column rejects.
+	mentions := msg.Mentions
+	if mentions == nil {
+		mentions = []string{}
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return models.Message{}, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		"INSERT INTO messages (room_id, kind, username, content, user_id, image_path, mentions) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at",
+		msg.RoomID, msg.Kind, msg.Username, msg.Content, msg.UserID, imagePath, pq.Array(mentions),
+	).Scan(&msg.ID, &msg.CreatedAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+		if pqErr.Constraint == "messages_room_id_fkey" {
+			return models.Message{}, ErrRoomNotFound
+		}
+		return models.Message{}, ErrNoSession
+	}
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	if msg.ReplyTo, err = linkReplies(tx, msg.ID, msg.RoomID, msg.ReplyTo); err != nil {
+		return models.Message{}, err
+	}
+
+	return msg, tx.Commit()
+}
+
+// linkReplies records message id as a reply to each message in replyTo that
+// exists in roomID, and returns those, in order.
+func linkReplies(tx *sql.Tx, id int, roomID string, replyTo []int) ([]int, error) {
+	if len(replyTo) == 0 {
+		return nil, nil
+	}
+	var linked pq.Int64Array
+	err := tx.QueryRow(`
+		WITH linked AS (
+			INSERT INTO message_replies (message_id, reply_id)
+			SELECT id, $2 FROM messages WHERE id = ANY($1) AND room_id = $3
+			RETURNING message_id
+		)
+		SELECT ARRAY(SELECT message_id FROM linked ORDER BY message_id)
+	`, pq.Array(int64s(replyTo)), id, roomID).Scan(&linked)
+	if err != nil {
+		return nil, err
+	}
+	return ints(linked), nil
+}
+
+func (p *Postgres) InsertDirect(dm models.DirectMessage) (models.DirectMessage, error) {
+	err := p.db.QueryRow(
+		"INSERT INTO direct_messages (sender_id, recipient_id, content) VALUES ($1, $2, $3) RETURNING id, created_at",
+		dm.SenderID, dm.RecipientID, dm.Content,
+	).Scan(&dm.ID, &dm.CreatedAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+		return models.DirectMessage{}, ErrNoSession
+	}
+	if err != nil {
+		return models.DirectMessage{}, err
+	}
+	return dm, nil
+}
+
+func (p *Postgres) Conversation(userID, peerID string, limit int) ([]models.DirectMessage, error) {
+	rows, err := p.db.Query(`
+		SELECT id, sender_id, recipient_id, content, created_at
+		FROM (
+			SELECT * FROM direct_messages
+			WHERE LEAST(sender_id, recipient_id) = LEAST($1::varchar, $2::varchar)
+			AND GREATEST(sender_id, recipient_id) = GREATEST($1::varchar, $2::varchar)
+			ORDER BY created_at DESC LIMIT $3
+		) sub
+		ORDER BY created_at ASC
+	`, userID, peerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dms []models.DirectMessage
+	for rows.Next() {
+		var dm models.DirectMessage
+		if err := rows.Scan(&dm.ID, &dm.SenderID, &dm.RecipientID, &dm.Content, &dm.CreatedAt); err != nil {
+			return nil, err
+		}
+		dms = append(dms, dm)
+	}
+	return dms, rows.Err()
+}
+
+func (p *Postgres) Get(id int) (models.Message, error) {
+	row := p.db.QueryRow("SELECT "+messageColumns+" FROM messages WHERE id = $1", id)
+	m, err := scanMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Message{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Message{}, err
+	}
+	return p.withDetails(m)
+}
+
+func (p *Postgres) Visible(id int) (models.Message, error) {
+	row := p.db.QueryRow(`
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE id = $1 AND user_id IN (SELECT user_id FROM sessions WHERE expires_at > now())
+	`, id)
+	m, err := scanMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Message{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Message{}, err
+	}
+	return p.withDetails(m)
+}
+
+func (p *Postgres) Recent(roomID string, limit int) ([]models.Message, error) {
+	rows, err := p.db.Query(`
+		SELECT `+messageColumns+`
+		FROM (
+			SELECT m.* FROM messages m
+			JOIN sessions s ON s.user_id = m.user_id
+			WHERE m.room_id = $1 AND s.expires_at > now()
+			ORDER BY m.id DESC LIMIT $2
+		) sub
+		ORDER BY id ASC
+	`, roomID, limit)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	return messages, p.loadDetails(messages)
+}
+
+func (p *Postgres) Before(roomID string, beforeID, limit int) ([]models.Message, error) {
+	rows, err := p.db.Query(`
+		SELECT `+messageColumns+`
+		FROM (
+			SELECT m.* FROM messages m
+			JOIN sessions s ON s.user_id = m.user_id
+			WHERE m.room_id = $1 AND m.id < $2 AND s.expires_at > now()
+			ORDER BY m.id DESC LIMIT $3
+		) sub
+		ORDER BY id ASC
+	`, roomID, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	return messages, p.loadDetails(messages)
+}
+
+func (p *Postgres) After(roomID string, afterID, limit int) ([]models.Message, error) {
+	rows, err := p.db.Query(`
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE room_id = $1 AND id > $2
+			AND user_id IN (SELECT user_id FROM sessions WHERE expires_at > now())
+		ORDER BY id ASC
+		LIMIT $3
+	`, roomID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	return messages, p.loadDetails(messages)
+}
+
+func (p *Postgres) Search(roomID string, terms []string, window, limit int) ([]models.Message, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	rows, err := p.db.Query(`
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE id IN (
+			SELECT m.id FROM messages m
+			JOIN sessions s ON s.user_id = m.user_id
+			WHERE m.room_id = $1 AND s.expires_at > now()
+			ORDER BY m.id DESC LIMIT $3
+		) AND `+searchVector+` @@ to_tsquery('simple', $2)
+		ORDER BY id DESC
+		LIMIT $4
+	`, roomID, tsQuery(terms), window, limit)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	return messages, p.loadDetails(messages)
+}
+
+func (p *Postgres) withDetails(m models.Message) (models.Message, error) {
+	messages := []models.Message{m}
+	if err := p.loadDetails(messages); err != nil {
+		return models.Message{}, err
+	}
+	return messages[0], nil
+}
+
+// loadDetails fills in ReplyTo, Replies and Reactions for messages in place.
+func (p *Postgres) loadDetails(messages []models.Message) error {
+	if err := p.loadReplies(messages); err != nil {
+		return err
+	}
+	return p.loadReactions(messages)
+}
+
+// loadReplies fills in ReplyTo and Replies for messages in place.
+func (p *Postgres) loadReplies(messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	index := make(map[int]int, len(messages))
+	ids := make([]int64, len(messages))
+	for i, m := range messages {
+		index[m.ID] = i
+		ids[i] = int64(m.ID)
+	}
+
+	rows, err := p.db.Query(`
+		SELECT message_id, reply_id FROM message_replies
+		WHERE message_id = ANY($1) OR reply_id = ANY($1)
+		ORDER BY message_id, reply_id
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID, replyID int
+		if err := rows.Scan(&messageID, &replyID); err != nil {
+			return err
+		}
+		if i, ok := index[messageID]; ok {
+			messages[i].Replies = append(messages[i].Replies, replyID)
+		}
+		if i, ok := index[replyID]; ok {
+			messages[i].ReplyTo = append(messages[i].ReplyTo, messageID)
+		}
+	}
+	return rows.Err()
+}
+
+// loadReactions fills in Reactions for messages in place.
+func (p *Postgres) loadReactions(messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	index := make(map[int]int, len(messages))
+	ids := make([]int64, len(messages))
+	for i, m := range messages {
+		index[m.ID] = i
+		ids[i] = int64(m.ID)
+	}
+
+	rows, err := p.db.Query(`
+		SELECT message_id, emoji, user_id FROM message_reactions
+		WHERE message_id = ANY($1)
+		ORDER BY message_id, min(created_at) OVER (PARTITION BY message_id, emoji), emoji, created_at
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int
+		var emoji, userID string
+		if err := rows.Scan(&messageID, &emoji, &userID); err != nil {
+			return err
+		}
+		m := &messages[index[messageID]]
+		if n := len(m.Reactions); n > 0 && m.Reactions[n-1].Emoji == emoji {
+			m.Reactions[n-1].UserIDs = append(m.Reactions[n-1].UserIDs, userID)
+		} else {
+			m.Reactions = append(m.Reactions, models.Reaction{Emoji: emoji, UserIDs: []string{userID}})
+		}
+	}
+	return rows.Err()
+}
+
+// ToggleReaction removes userID's reaction if it is there and adds it
+// otherwise, in one transaction.
+func (p *Postgres) ToggleReaction(id int, userID, emoji string) (models.Message, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return models.Message{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		"DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3",
+		id, userID, emoji,
+	)
+	if err != nil {
+		return models.Message{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return models.Message{}, err
+	} else if n == 0 {
+		// A concurrent toggle may have added the same reaction since the
+		// DELETE; it stays, as if this one came first.
+		_, err = tx.Exec(
+			"INSERT INTO message_reactions (message_id, user_id, emoji) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+			id, userID, emoji,
+		)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+			if pqErr.Constraint == "message_reactions_message_id_fkey" {
+				return models.Message{}, ErrNotFound
+			}
+			return models.Message{}, ErrNoSession
+		}
+		if err != nil {
+			return models.Message{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Message{}, err
+	}
+	return p.Get(id)
+}
+
+// Edit archives the current content and updates the message in one
+// statement. FOR UPDATE makes a concurrent edit wait, so each revision holds
+// the content the edit actually replaced.
+func (p *Postgres) Edit(id int, content string, replyTo []int, mentions []string) (models.Message, error) {
+	// A nil slice would be sent as NULL, which the column rejects.
+	if mentions == nil {
+		mentions = []string{}
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return models.Message{}, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(`
+		WITH old AS (
+			SELECT id, content FROM messages WHERE id = $1 FOR UPDATE
+		), archived AS (
+			INSERT INTO message_revisions (message_id, content)
+			SELECT id, content FROM old
+		)
+		UPDATE messages SET content = $2, mentions = $3, edited_at = now()
+		WHERE id = (SELECT id FROM old)
+		RETURNING `+messageColumns, id, content, pq.Array(mentions))
+	m, err := scanMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Message{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM message_replies WHERE reply_id = $1", id); err != nil {
+		return models.Message{}, err
+	}
+	if _, err := linkReplies(tx, id, m.RoomID, replyTo); err != nil {
+		return models.Message{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Message{}, err
+	}
+	return p.withDetails(m)
+}
+
+func (p *Postgres) Pin(id int, pinned bool) (models.Message, error) {
+	row := p.db.QueryRow(`
+		UPDATE messages SET pinned_at = CASE WHEN $2 THEN COALESCE(pinned_at, now()) END
+		WHERE id = $1
+		RETURNING `+messageColumns, id, pinned)
+	m, err := scanMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Message{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Message{}, err
+	}
+	return p.withDetails(m)
+}
+
+func (p *Postgres) Pinned(roomID string) ([]models.Message, error) {
+	rows, err := p.db.Query(`
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE room_id = $1 AND pinned_at IS NOT NULL
+			AND user_id IN (SELECT user_id FROM sessions WHERE expires_at > now())
+		ORDER BY pinned_at ASC
+	`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	return messages, p.loadDetails(messages)
+}
+
+func (p *Postgres) Revisions(id int) ([]models.Revision, error) {
+	rows, err := p.db.Query(
+		"SELECT message_id, content, replaced_at FROM message_revisions WHERE message_id = $1 ORDER BY id",
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []models.Revision
+	for rows.Next() {
+		var r models.Revision
+		if err := rows.Scan(&r.MessageID, &r.Content, &r.ReplacedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+func (p *Postgres) Delete(id int) (models.Message, error) {
+	deleted, err := p.deleteReturning("DELETE FROM messages WHERE id = $1", id)
+	if err != nil {
+		return models.Message{}, err
+	}
+	if len(deleted) == 0 {
+		return models.Message{}, ErrNotFound
+	}
+	return deleted[0], nil
+}
+
+func (p *Postgres) DeleteAll() ([]models.Message, error) {
+	return p.deleteSessions("TRUE")
+}
+
+func (p *Postgres) TrimToLimit(limit int) ([]models.Message, error) {
+	_, err := p.db.Exec(`
+		DELETE FROM direct_messages WHERE id IN (
+			SELECT id FROM (
+				SELECT id, row_number() OVER (
+					PARTITION BY LEAST(sender_id, recipient_id), GREATEST(sender_id, recipient_id)
+					ORDER BY created_at DESC
+				) AS n
+				FROM direct_messages
+			) ranked
+			WHERE n > $1
+		)`, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.deleteReturning(`
+		DELETE FROM messages WHERE id IN (
+			SELECT id FROM (
+				SELECT id, row_number() OVER (PARTITION BY room_id ORDER BY id DESC) AS n
+				FROM messages
+				WHERE pinned_at IS NULL
+			) ranked
+			WHERE n > $1
+		)`, limit)
+}
+
+func (p *Postgres) Close() error {
+	return p.db.Close()
+}
+
+func (p *Postgres) deleteReturning(query string, args ...interface{}) ([]models.Message, error) {
+	rows, err := p.db.Query(query+" RETURNING "+messageColumns, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMessage(s scanner) (models.Message, error) {
+	var m models.Message
+	var imagePath sql.NullString
+	var editedAt, pinnedAt sql.NullTime
+	var mentions pq.StringArray
+	if err := s.Scan(&m.ID, &m.RoomID, &m.Username, &m.Content, &m.CreatedAt, &m.UserID, &imagePath, &editedAt, &mentions, &pinnedAt, &m.Kind); err != nil {
+		return models.Message{}, err
+	}
+	if imagePath.Valid {
+		m.ImagePath = imagePath.String
+	}
+	if editedAt.Valid {
+		m.EditedAt = editedAt.Time
+	}
+	if pinnedAt.Valid {
+		m.PinnedAt = pinnedAt.Time
+	}
+	if len(mentions) > 0 {
+		m.Mentions = mentions
+	}
+	return m, nil
+}
+
+func scanRoom(s scanner) (models.Room, error) {
+	var r models.Room
+	var accessKey, createdBy sql.NullString
+	if err := s.Scan(&r.ID, &r.CreatedAt, &accessKey, &createdBy); err != nil {
+		return models.Room{}, err
+	}
+	r.AccessKey = accessKey.String
+	r.CreatedBy = createdBy.String
+	return r, nil
+}
+
+func scanMessages(rows *sql.Rows) ([]models.Message, error) {
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// nullString sends an empty string as NULL.
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func int64s(ids []int) []int64 {
+	out := make([]int64, len(ids))
+	for i, id := range ids {
+		out[i] = int64(id)
+	}
+	return out
+}
+
+func ints(ids []int64) []int {
+	out := make([]int, len(ids))
+	for i, id := range ids {
+		out[i] = int(id)
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxSearchTerms bounds how many words of a query are searched for.
+const maxSearchTerms = 8
+
+// SearchTerms splits a search query into the lowercased words a message must
+// contain, as prefixes of its own words. Punctuation separates words, so
+// "github.com" searches for "github" and "com".
+func SearchTerms(query string) []string {
+	terms := searchWords(query)
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	return terms
+}
+
+// searchWords returns the lowercased runs of letters and digits in s.
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchesSearch reports whether content has a word starting with each of
+// terms.
+func matchesSearch(content string, terms []string) bool {
+	words := searchWords(content)
+	for _, term := range terms {
+		found := false
+		for _, word := range words {
+			if strings.HasPrefix(word, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// searchVector is the Postgres text search document for a message: the
+// runs of letters and digits searchWords finds, so that tsQuery matches the
+// same messages as matchesSearch. The parser would otherwise keep a URL,
+// email or decimal number as one lexeme. It must match the expression of
+// the idx_messages_content_search index.
+const searchVector = `to_tsvector('simple', regexp_replace(lower(content), '[^[:alnum:]]+', ' ', 'g'))`
+
+// tsQuery builds a Postgres text search query for terms, to be matched
+// against searchVector. Terms only hold letters and digits, so they need no
+// quoting.
+func tsQuery(terms []string) string {
+	prefixes := make([]string, len(terms))
+	for i, term := range terms {
+		prefixes[i] = term + ":*"
+	}
+	return strings.Join(prefixes, " & ")
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"temp0ral-chat/config"
+	"temp0ral-chat/models"
+	"temp0ral-chat/utils"
+	"time"
+)
+
+var (
+	ErrNotFound  = errors.New("message not found")
+	ErrNoSession = errors.New("session not registered with the store")
+
+	ErrRoomNotFound = errors.New("room not found")
+	ErrRoomExists   = errors.New("room already exists")
+)
+
+// MessageStore persists chat messages and the sessions that own them. A
+// message can only be inserted for a registered session, and ending a
+// session removes its messages with it. Methods that delete return the
+// removed messages so callers can clean up their uploaded images. A
+// message's revisions and reactions go with it, and a session's reactions
+// go with the session. Every message belongs to a room.
+// Direct messages belong to both their sender's and their recipient's
+// session and go when either ends.
+type MessageStore interface {
+	// CreateRoom adds a room and returns it with CreatedAt filled in, or
+	// ErrRoomExists if the ID is taken.
+	CreateRoom(room models.Room) (models.Room, error)
+	// Room returns a room, or ErrRoomNotFound.
+	Room(id string) (models.Room, error)
+	// Rooms returns every room, oldest first.
+	Rooms() ([]models.Room, error)
+	// DeleteRoom removes a room and every message in it. Deleting a room
+	// that does not exist is not an error.
+	DeleteRoom(id string) ([]models.Message, error)
+
+	AddSession(session models.Session) error
+	// EndSession removes the session and every message it wrote.
+	EndSession(userID string) ([]models.Message, error)
+	// PruneSessions ends every session not listed in activeUserIDs that was
+	// created before createdBefore. Later sessions are spared, as they may
+	// have started after activeUserIDs was read.
+	PruneSessions(activeUserIDs []string, createdBefore time.Time) ([]models.Message, error)
+
+	// Insert stores msg and returns it with ID and CreatedAt filled in. It
+	// records msg as a reply to each message in ReplyTo, dropping those
+	// that no longer exist or are in another room. It returns
+	// ErrRoomNotFound if msg.RoomID does not exist.
+	Insert(msg models.Message) (models.Message, error)
+	// InsertDirect stores a direct message and returns it with ID and
+	// CreatedAt filled in, or ErrNoSession if either party is not registered.
+	InsertDirect(dm models.DirectMessage) (models.DirectMessage, error)
+	// Conversation returns up to limit of the newest direct messages
+	// between two users, oldest first.
+	Conversation(userID, peerID string, limit int) ([]models.DirectMessage, error)
+
+	// Get returns a message with ReplyTo, Replies and Reactions filled in.
+	Get(id int) (models.Message, error)
+	// Visible is Get restricted to messages Recent would return, i.e. whose
+	// author's session is still live. Others are reported as ErrNotFound.
+	Visible(id int) (models.Message, error)
+	// Recent returns up to limit of the newest messages in a room written
+	// by live sessions, oldest first, with ReplyTo, Replies and Reactions
+	// filled in.
+	Recent(roomID string, limit int) ([]models.Message, error)
+	// Before is Recent for the messages older than message beforeID, so
+	// history can be paged back from the oldest message on the page.
+	Before(roomID string, beforeID, limit int) ([]models.Message, error)
+	// After returns up to limit of the oldest messages in a room newer
+	// than message afterID, written by live sessions, oldest first, so the
+	// whole history can be read a page at a time from afterID 0.
+	After(roomID string, afterID, limit int) ([]models.Message, error)
+	// Search returns up to limit of the messages among Recent(roomID,
+	// window) that have a word starting with each of terms, as produced by
+	// SearchTerms, newest first.
+	Search(roomID string, terms []string, window, limit int) ([]models.Message, error)
+	// Edit replaces a message's content, keeping the old content as a
+	// revision, and returns the updated message. The messages it replies to
+	// and the users it mentions are replaced with those of the new content;
+	// replyTo is filtered as by Insert.
+	Edit(id int, content string, replyTo []int, mentions []string) (models.Message, error)
+	// ToggleReaction adds userID's reaction with emoji to a message, or
+	// removes it if it is already there, and returns the updated message.
+	ToggleReaction(id int, userID, emoji string) (models.Message, error)
+	// Pin pins a message to its room, or unpins it, and returns the updated
+	// message. Pinning a pinned message keeps its original PinnedAt.
+	Pin(id int, pinned bool) (models.Message, error)
+	// Pinned returns the pinned messages in a room written by live
+	// sessions, in the order they were pinned.
+	Pinned(roomID string) ([]models.Message, error)
+	// Revisions returns a message's earlier versions, oldest first.
+	Revisions(id int) ([]models.Revision, error)
+	// Delete removes a single message, returning ErrNotFound if it is gone.
+	Delete(id int) (models.Message, error)
+	// DeleteAll removes every session and message.
+	DeleteAll() ([]models.Message, error)
+	// TrimToLimit removes all but the newest limit messages of each room,
+	// and of each direct conversation. Pinned messages are never trimmed
+	// and do not count towards the limit.
+	TrimToLimit(limit int) ([]models.Message, error)
+	Close() error
+}
+
+var Messages MessageStore
+
+// Setup opens the message store selected by cfg.Store.Driver.
+func Setup(cfg *config.Config) error {
+	switch cfg.Store.Driver {
+	case "postgres":
+		if err := utils.SetupDatabase(cfg.Database); err != nil {
+			return err
+		}
+		Messages = NewPostgres(utils.DB)
+
+		// Sessions live in memory, so any left in the database belong to a
+		// previous process and can never be resumed.
+		deleted, err := Messages.PruneSessions(nil, time.Now())
+		if err != nil {
+			return fmt.Errorf("pruning stale sessions: %w", err)
+		}
+		if len(deleted) > 0 {
+			log.Printf("Removed %d messages left over from a previous run", len(deleted))
+		}
+	case "memory":
+		Messages = NewMemory(cfg.Messages.HistoryLimit)
+	default:
+		return fmt.Errorf("unknown store driver %q", cfg.Store.Driver)
+	}
+
+	if _, err := Messages.CreateRoom(models.Room{ID: cfg.Rooms.Default}); err != nil && !errors.Is(err, ErrRoomExists) {
+		return fmt.Errorf("creating default room: %w", err)
+	}
+	return nil
+}
+
+func Close() error {
+	if Messages != nil {
+		return Messages.Close()
+	}
+	return nil
+}
@@ -4,13 +4,22 @@ import (
 	"html"
 	"html/template"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 var postReferencePattern = regexp.MustCompile(`>>\d+`)
 
-func PostProcessor(content string) string {
+// mentionPattern matches @ followed by the 8 hex digits of a short user ID,
+// as inserted by clicking a username.
+var mentionPattern = regexp.MustCompile(`(^|[^\w@])@([0-9a-fA-F]{8})\b`)
+
+// PostProcessor renders message content as HTML. Mentions of the user IDs in
+// mentions are highlighted.
+func PostProcessor(content string, mentions ...string) string {
 	lines := strings.Split(content, "\n")
 	for i, line := range lines {
 		trimmedLine := strings.TrimSpace(line)
@@ -20,7 +29,7 @@ func PostProcessor(content string) string {
 		} else if strings.HasPrefix(trimmedLine, "<") {
 			lines[i] = `<span class="bluetext">` + template.HTMLEscapeString(line) + `</span>`
 		} else {
-			lines[i] = ProcessLineWithReferences(line)
+			lines[i] = highlightMentions(ProcessLineWithReferences(line), mentions)
 		}
 	}
 
@@ -28,6 +37,119 @@ func PostProcessor(content string) string {
 	return processedContent
 }
 
+// linkedLines returns the lines of content that PostProcessor renders with
+// links and mentions, i.e. everything but greentext and bluetext.
+func linkedLines(content string) []string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		trimmedLine := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmedLine, ">") && !strings.HasPrefix(trimmedLine, ">>") ||
+			strings.HasPrefix(trimmedLine, "<") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// PostReferences returns the IDs linked with >>id in content, ascending and
+// without duplicates. Every ID returned is rendered as a link.
+func PostReferences(content string) []int {
+	var ids []int
+	for _, line := range linkedLines(content) {
+		for _, match := range postReferencePattern.FindAllString(line, -1) {
+			if id, err := strconv.Atoi(match[2:]); err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+// Mentions returns the short user IDs @mentioned in content, lowercased and
+// without duplicates, from the lines PostProcessor would highlight them in.
+func Mentions(content string) []string {
+	var shortIDs []string
+	for _, line := range linkedLines(content) {
+		for _, match := range mentionPattern.FindAllStringSubmatch(line, -1) {
+			shortIDs = append(shortIDs, strings.ToLower(match[2]))
+		}
+	}
+	slices.Sort(shortIDs)
+	return slices.Compact(shortIDs)
+}
+
+// highlightMentions wraps each @shortid in an already escaped line that
+// belongs to one of mentions. Unresolved ones stay plain text.
+func highlightMentions(line string, mentions []string) string {
+	if len(mentions) == 0 {
+		return line
+	}
+	return mentionPattern.ReplaceAllStringFunc(line, func(match string) string {
+		at := strings.IndexByte(match, '@')
+		shortID := strings.ToLower(match[at+1:])
+		for _, userID := range mentions {
+			if strings.HasPrefix(userID, shortID) {
+				return match[:at] + `<span class="reply-mention" data-user-id="` +
+					html.EscapeString(userID) + `">` + match[at:] + `</span>`
+			}
+		}
+		return match
+	})
+}
+
+// HighlightTerms marks the words in rendered HTML that start with one of
+// terms, which must be lowercase. Tags and character references are left
+// alone.
+func HighlightTerms(rendered string, terms []string) string {
+	if len(terms) == 0 {
+		return rendered
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(rendered); {
+		if c := rendered[i]; c == '<' || c == '&' {
+			closer := byte('>')
+			if c == '&' {
+				closer = ';'
+			}
+			end := len(rendered)
+			if j := strings.IndexByte(rendered[i:], closer); j >= 0 {
+				end = i + j + 1
+			}
+			b.WriteString(rendered[i:end])
+			i = end
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(rendered[i:])
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			b.WriteString(rendered[i : i+size])
+			i += size
+			continue
+		}
+
+		end := i
+		for end < len(rendered) {
+			r, size := utf8.DecodeRuneInString(rendered[end:])
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				break
+			}
+			end += size
+		}
+		word := rendered[i:end]
+		lower := strings.ToLower(word)
+		if slices.ContainsFunc(terms, func(term string) bool { return strings.HasPrefix(lower, term) }) {
+			b.WriteString(`<mark class="search-match">` + word + `</mark>`)
+		} else {
+			b.WriteString(word)
+		}
+		i = end
+	}
+	return b.String()
+}
+
 func ProcessLineWithReferences(line string) string {
 	var anchors []string
 	placeholder := "{{POST_REF}}"
@@ -37,8 +159,9 @@ func ProcessLineWithReferences(line string) string {
 		if _, err := strconv.Atoi(postIDStr); err != nil {
 			return match
 		}
-		anchor := `<a href="#p` + html.EscapeString(postIDStr) +
-			`" class="post-reference">` + html.EscapeString(match) + `</a>`
+		anchor := `<a href="#msg-` + html.EscapeString(postIDStr) +
+			`" class="post-reference" data-ref="` + html.EscapeString(postIDStr) + `">` +
+			html.EscapeString(match) + `</a>`
 		anchors = append(anchors, anchor)
 		return placeholder
 	})
@@ -5,20 +5,34 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"temp0ral-chat/models"
+	"temp0ral-chat/config"
 )
 
 var DB *sql.DB
 
-func SetupDatabase() error {
+// SetupDatabase connects to Postgres and brings the schema up to date.
+func SetupDatabase(cfg config.DatabaseConfig) error {
+	if err := ConnectDatabase(cfg); err != nil {
+		return err
+	}
+
+	if err := Migrate(DB); err != nil {
+		return fmt.Errorf("migration error: %w", err)
+	}
+
+	return nil
+}
+
+func ConnectDatabase(cfg config.DatabaseConfig) error {
 	var err error
 
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		models.DBHost,
-		models.DBPort,
-		models.DBUser,
-		models.DBPassword,
-		models.DBName,
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		cfg.Host,
+		cfg.Port,
+		cfg.User,
+		cfg.Password,
+		cfg.Name,
+		cfg.SSLMode,
 	)
 
 	DB, err = sql.Open("postgres", connStr)
@@ -35,51 +49,10 @@ func SetupDatabase() error {
 
 	log.Println("Database connection established successfully")
 
-	if err := createTables(); err != nil {
-		return err
-	}
-
-	if err := createUploadsDirectory(); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func createTables() error {
-	_, err := DB.Exec(`
-		CREATE TABLE IF NOT EXISTS messages (
-			id SERIAL PRIMARY KEY,
-			username VARCHAR(255) NOT NULL,
-			content TEXT NOT NULL,
-			user_id VARCHAR(255) NOT NULL,
-			image_path VARCHAR(255),
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)
-	`)
-	if err != nil {
-		return fmt.Errorf("table creation error: %w", err)
-	}
-
-	_, err = DB.Exec(`
-		CREATE INDEX IF NOT EXISTS idx_messages_user_id ON messages(user_id)
-	`)
-	if err != nil {
-		return fmt.Errorf("user_id index creation error: %w", err)
-	}
-
-	_, err = DB.Exec(`
-		CREATE INDEX IF NOT EXISTS idx_messages_created_at ON messages(created_at DESC)
-	`)
-	if err != nil {
-		return fmt.Errorf("created_at index creation error: %w", err)
-	}
-
-	log.Println("Database tables and indexes created successfully")
 	return nil
 }
 
-func createUploadsDirectory() error {
+func CreateUploadsDirectory() error {
 	err := os.MkdirAll("./uploads", 0755)
 	if err != nil {
 		return fmt.Errorf("error creating uploads directory: %w", err)
@@ -0,0 +1,21 @@
+-- Baseline schema. IF NOT EXISTS lets databases created before versioned
+-- migrations adopt it.
+CREATE TABLE IF NOT EXISTS messages (
+	id SERIAL PRIMARY KEY,
+	username VARCHAR(255) NOT NULL,
+	content TEXT NOT NULL,
+	user_id VARCHAR(255) NOT NULL,
+	image_path VARCHAR(255),
+	created_at TIMESTAMPTZ DEFAULT now()
+);
+
+-- Tables adopted from before versioned migrations have a created_at without
+-- a time zone, which lib/pq reads back as UTC. Their values were written in
+-- the session time zone. On a new table this changes nothing.
+ALTER TABLE messages
+	ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone'),
+	ALTER COLUMN created_at SET DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS idx_messages_user_id ON messages(user_id);
+
+CREATE INDEX IF NOT EXISTS idx_messages_created_at ON messages(created_at DESC);
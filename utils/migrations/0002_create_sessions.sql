@@ -0,0 +1,17 @@
+-- Sessions own their messages so ending one removes its messages in a single
+-- cascading delete instead of a NOT IN scan over the messages table.
+CREATE TABLE sessions (
+	user_id VARCHAR(255) PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_sessions_expires_at ON sessions(expires_at);
+
+-- Sessions used to live only in memory, so existing messages have no owner
+-- left to reference.
+DELETE FROM messages;
+
+ALTER TABLE messages
+	ADD CONSTRAINT messages_user_id_fkey
+	FOREIGN KEY (user_id) REFERENCES sessions(user_id) ON DELETE CASCADE;
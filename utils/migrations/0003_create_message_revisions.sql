@@ -0,0 +1,12 @@
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMPTZ;
+
+-- Earlier versions of edited messages. They cascade with the message, so
+-- every path that removes a message removes its history too.
+CREATE TABLE message_revisions (
+	id SERIAL PRIMARY KEY,
+	message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	content TEXT NOT NULL,
+	replaced_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_message_revisions_message_id ON message_revisions(message_id);
@@ -0,0 +1,9 @@
+-- Who replied to what, recorded from >>id references when a message is
+-- posted. Rows go away with either message.
+CREATE TABLE message_replies (
+	message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	reply_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	PRIMARY KEY (message_id, reply_id)
+);
+
+CREATE INDEX idx_message_replies_reply_id ON message_replies(reply_id);
@@ -0,0 +1,3 @@
+-- User IDs @mentioned in a message, resolved against the live sessions when
+-- it was posted.
+ALTER TABLE messages ADD COLUMN mentions TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,16 @@
+-- Rooms split the chat into separate conversations. Everything posted before
+-- rooms existed belongs to the lobby.
+CREATE TABLE rooms (
+	id VARCHAR(32) PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO rooms (id) VALUES ('lobby');
+
+ALTER TABLE messages
+	ADD COLUMN room_id VARCHAR(32) NOT NULL DEFAULT 'lobby'
+	CONSTRAINT messages_room_id_fkey REFERENCES rooms(id) ON DELETE CASCADE;
+
+ALTER TABLE messages ALTER COLUMN room_id DROP DEFAULT;
+
+CREATE INDEX idx_messages_room_id_id ON messages(room_id, id DESC);
@@ -0,0 +1,5 @@
+-- Private rooms carry their own access key and are deleted, messages and
+-- all, once the last session that may enter them has ended.
+ALTER TABLE rooms
+	ADD COLUMN access_key VARCHAR(64),
+	ADD COLUMN created_by VARCHAR(16);
@@ -0,0 +1,12 @@
+-- Direct messages belong to both parties' sessions, so ending either one
+-- removes the conversation through the same cascade as public messages.
+CREATE TABLE direct_messages (
+	id SERIAL PRIMARY KEY,
+	sender_id VARCHAR(255) NOT NULL REFERENCES sessions(user_id) ON DELETE CASCADE,
+	recipient_id VARCHAR(255) NOT NULL REFERENCES sessions(user_id) ON DELETE CASCADE,
+	content TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_direct_messages_conversation
+	ON direct_messages(LEAST(sender_id, recipient_id), GREATEST(sender_id, recipient_id), created_at DESC);
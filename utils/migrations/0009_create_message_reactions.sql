@@ -0,0 +1,9 @@
+-- One row per user per reaction on a message. Rows go away with the message,
+-- and with the session of whoever reacted.
+CREATE TABLE message_reactions (
+	message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	user_id VARCHAR(255) NOT NULL REFERENCES sessions(user_id) ON DELETE CASCADE,
+	emoji VARCHAR(64) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (message_id, user_id, emoji)
+);
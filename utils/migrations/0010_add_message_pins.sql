@@ -0,0 +1,5 @@
+-- When a moderator pinned the message to its room. Pinned messages are kept
+-- past the history limit, but still go with their author's session.
+ALTER TABLE messages ADD COLUMN pinned_at TIMESTAMPTZ;
+
+CREATE INDEX idx_messages_pinned ON messages(room_id, pinned_at) WHERE pinned_at IS NOT NULL;
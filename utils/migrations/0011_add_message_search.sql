@@ -0,0 +1,7 @@
+-- Full-text search over message content. The default text search parser
+-- keeps hosts, URLs, emails and numbers like 3.14 as single lexemes, while
+-- search queries are split on punctuation, so the index holds the same
+-- letter and digit runs the queries are made of. Queries must use the same
+-- expression for the index to apply.
+CREATE INDEX idx_messages_content_search ON messages
+	USING GIN (to_tsvector('simple', regexp_replace(lower(content), '[^[:alnum:]]+', ' ', 'g')));
@@ -0,0 +1,3 @@
+-- How a message was posted: '' for typed text, 'action' for /me and
+-- 'system' for messages the server writes, such as /roll results.
+ALTER TABLE messages ADD COLUMN kind VARCHAR(16) NOT NULL DEFAULT '';